@@ -15,6 +15,8 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 
+	"github.com/example-pipeline/impex/internal/list"
+
 	"log/slog"
 )
 
@@ -34,7 +36,7 @@ func Run(args Arguments) error {
 
 	// Parse the input file.
 	log.Info("Parsing input file")
-	data, err := os.ReadFile(args.FileName)
+	downloads, err := list.Read(args.FileName)
 	if err != nil {
 		return fmt.Errorf("failed to open input file: %w", err)
 	}
@@ -45,23 +47,14 @@ func Run(args Arguments) error {
 	}
 
 	// Download OCI container images.
-	downloads := strings.Split(string(data), "\n")
 	var errs error
 	var downloadsComplete int
-	for _, container := range downloads {
-		if len(container) == 0 {
-			downloadsComplete++
-			continue
-		}
-		if strings.HasPrefix(container, "#") {
-			log.Info("Skipping", slog.String("name", container), slog.Int("total", len(downloads)))
-			downloadsComplete++
-			continue
-		}
+	for _, entry := range downloads {
+		container := entry.Value
 		log.Info("Downloading", slog.String("name", container), slog.Int("total", len(downloads)))
 		err := download(container)
 		if err != nil {
-			errs = errors.Join(err)
+			errs = errors.Join(errs, entry.Wrap(err))
 		}
 		downloadsComplete++
 	}
@@ -14,6 +14,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v55/github"
 
+	"github.com/example-pipeline/impex/internal/list"
+
 	"log/slog"
 )
 
@@ -50,29 +52,20 @@ func Export(args Arguments) error {
 
 	// Parse the input file.
 	log.Info("Parsing input file")
-	data, err := os.ReadFile(args.FileName)
+	downloads, err := list.Read(args.FileName)
 	if err != nil {
 		return fmt.Errorf("failed to open input file: %w", err)
 	}
 
 	// Download git repos.
-	downloads := strings.Split(string(data), "\n")
 	var errs error
 	var downloadsComplete int
-	for _, repo := range downloads {
-		if len(repo) == 0 {
-			downloadsComplete++
-			continue
-		}
-		if strings.HasPrefix(repo, "#") {
-			log.Info("Skipping", slog.String("name", repo), slog.Int("total", len(downloads)))
-			downloadsComplete++
-			continue
-		}
+	for _, entry := range downloads {
+		repo := entry.Value
 		log.Info("Downloading", slog.String("name", repo), slog.Int("total", len(downloads)))
 		err := download(repo, args.AccessToken)
 		if err != nil {
-			errs = errors.Join(err)
+			errs = errors.Join(errs, entry.Wrap(err))
 		}
 		downloadsComplete++
 	}
@@ -0,0 +1,243 @@
+package npm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+type ImportArguments struct {
+	// Directory containing the exported tarballs, e.g. package/npm.
+	Directory string
+	// RegistryURL is the base URL of the target registry.
+	// e.g. http://localhost:8081/repository/npm/
+	RegistryURL string
+	// Token is used for bearer authentication, if set.
+	Token string
+	// Username and Password are used for basic authentication, if set.
+	Username string
+	Password string
+	// Timeout is the maximum duration of each registry request, including uploading the tarball.
+	// Defaults to 5 minutes.
+	Timeout time.Duration
+	// Client is the HTTP client used to talk to the registry. Defaults to a client with the
+	// Timeout.
+	Client *http.Client
+	Log    *slog.Logger
+}
+
+func Import(args ImportArguments) error {
+	start := time.Now()
+
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	client := args.Client
+	if client == nil {
+		timeout := args.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Minute
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	r := &registry{
+		url:      strings.TrimSuffix(args.RegistryURL, "/"),
+		token:    args.Token,
+		username: args.Username,
+		password: args.Password,
+		client:   client,
+	}
+
+	// Find the tarballs.
+	log.Info("Finding tarballs", slog.String("directory", args.Directory))
+	var fileNames []string
+	err := filepath.WalkDir(args.Directory, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".tgz") {
+			fileNames = append(fileNames, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list tarballs: %w", err)
+	}
+
+	// Publish each tarball.
+	var errs error
+	var published, skipped int
+	latest := make(map[string]string)
+	for _, fileName := range fileNames {
+		ok, err := r.publish(fileName, latest)
+		if err != nil {
+			log.Error("Failed to publish", slog.String("file", fileName), slog.Any("error", err))
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", fileName, err))
+			continue
+		}
+		if !ok {
+			log.Info("Skipping, already exists", slog.String("file", fileName))
+			skipped++
+			continue
+		}
+		log.Info("Published", slog.String("file", fileName))
+		published++
+	}
+
+	log.Info("Complete", slog.Int("total", len(fileNames)), slog.Int("published", published), slog.Int("skipped", skipped), slog.String("duration", time.Now().Sub(start).String()))
+	return errs
+}
+
+// publish uploads the tarball to the registry, in the same way as `npm publish`.
+// It returns false if the version already exists in the registry.
+//
+// The latest tag is only moved to a version that isn't a prerelease, and is greater than the
+// current latest version, so that importing older versions doesn't change the version installed
+// by default. The latest version of each package is recorded in latest.
+func (r *registry) publish(fileName string, latest map[string]string) (ok bool, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := readPackageJSON(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return false, fmt.Errorf("package.json is missing name or version")
+	}
+
+	p, found, err := r.getPackument(name)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for existing version: %w", err)
+	}
+	if found {
+		if _, exists := p.Versions[version]; exists {
+			return false, nil
+		}
+	}
+	if _, ok := latest[name]; !ok && found {
+		latest[name] = p.DistTags["latest"]
+	}
+	distTags := make(map[string]string)
+	if isNewerRelease(version, latest[name]) {
+		distTags["latest"] = version
+	}
+
+	integrity, err := hashReader(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	shasum := sha1.Sum(data)
+	tarballName := name + "-" + version + ".tgz"
+	manifest["_id"] = name + "@" + version
+	manifest["dist"] = map[string]any{
+		"integrity": integrity,
+		"shasum":    hex.EncodeToString(shasum[:]),
+		"tarball":   r.tarballURL(name, version),
+	}
+	doc := map[string]any{
+		"_id":         name,
+		"name":        name,
+		"description": manifest["description"],
+		"versions": map[string]any{
+			version: manifest,
+		},
+		"_attachments": map[string]any{
+			tarballName: map[string]any{
+				"content_type": "application/octet-stream",
+				"data":         base64.StdEncoding.EncodeToString(data),
+				"length":       len(data),
+			},
+		},
+	}
+	// Leave out the dist-tags if latest isn't moved, since some registries reject an empty object.
+	if len(distTags) > 0 {
+		doc["dist-tags"] = distTags
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, r.packageURL(name), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("expected success status for PUT %q, but got %d: %s", req.URL, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if _, ok := distTags["latest"]; ok {
+		latest[name] = version
+	}
+	return true, nil
+}
+
+// isNewerRelease returns true if the version isn't a prerelease, and is greater than the current
+// version, or there isn't a valid current version.
+func isNewerRelease(version, current string) bool {
+	v, err := parseSemver(version)
+	if err != nil || len(v.Prerelease) > 0 {
+		return false
+	}
+	c, err := parseSemver(current)
+	if err != nil {
+		return true
+	}
+	return v.compare(c) > 0
+}
+
+// readPackageJSON reads the package.json from the root directory of an npm tarball.
+func readPackageJSON(r io.Reader) (manifest map[string]any, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("package.json not found in tarball")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tarball: %w", err)
+		}
+		// The root directory is usually "package", but not always.
+		_, fileName, ok := strings.Cut(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if !ok || fileName != "package.json" {
+			continue
+		}
+		if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("failed to decode package.json: %w", err)
+		}
+		return manifest, nil
+	}
+}
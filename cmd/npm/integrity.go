@@ -0,0 +1,125 @@
+package npm
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// hashAlgorithms are the Subresource Integrity algorithms that can be verified, strongest first.
+var hashAlgorithms = []struct {
+	Name string
+	New  func() hash.Hash
+}{
+	{Name: "sha512", New: sha512.New},
+	{Name: "sha384", New: sha512.New384},
+	{Name: "sha256", New: sha256.New},
+	// sha1 isn't part of the SRI specification, but is used by npm for old packages.
+	{Name: "sha1", New: sha1.New},
+}
+
+// integrity is a parsed Subresource Integrity string, e.g. "sha512-... sha1-...".
+type integrity struct {
+	// Algorithm is the strongest supported algorithm present in the string.
+	Algorithm string
+	// Digests are the base64 encoded digests for the algorithm. Any of them may match.
+	Digests []string
+}
+
+// parseIntegrity parses a whitespace separated Subresource Integrity string, selecting the
+// strongest supported algorithm. Options (e.g. "sha512-...?foo") and unsupported algorithms
+// are ignored.
+func parseIntegrity(s string) (i integrity, err error) {
+	digests := make(map[string][]string)
+	for _, token := range strings.Fields(s) {
+		token, _, _ = strings.Cut(token, "?")
+		algorithm, digest, ok := strings.Cut(token, "-")
+		if !ok || digest == "" {
+			continue
+		}
+		algorithm = strings.ToLower(algorithm)
+		digests[algorithm] = append(digests[algorithm], digest)
+	}
+	for _, ha := range hashAlgorithms {
+		if d, ok := digests[ha.Name]; ok {
+			return integrity{Algorithm: ha.Name, Digests: d}, nil
+		}
+	}
+	return i, fmt.Errorf("no supported hash algorithm in integrity %q", s)
+}
+
+// String returns the integrity in SRI format.
+func (i integrity) String() string {
+	values := make([]string, len(i.Digests))
+	for j, d := range i.Digests {
+		values[j] = i.Algorithm + "-" + d
+	}
+	return strings.Join(values, " ")
+}
+
+// verify hashes the reader with the integrity's algorithm, and returns an error if none of the
+// digests match.
+func (i integrity) verify(r io.Reader) (err error) {
+	actualHash, err := hashReaderAlgorithm(r, i.Algorithm)
+	if err != nil {
+		return err
+	}
+	for _, d := range i.Digests {
+		if i.Algorithm+"-"+d == actualHash {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected hash %q, got %q", i.String(), actualHash)
+}
+
+func hashReader(r io.Reader) (hash string, err error) {
+	return hashReaderAlgorithm(r, "sha512")
+}
+
+func hashFile(fileName string) (integrity string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashReaderAlgorithm(r io.Reader, algorithm string) (hash string, err error) {
+	for _, ha := range hashAlgorithms {
+		if ha.Name != algorithm {
+			continue
+		}
+		hasher := ha.New()
+		if _, err = io.Copy(hasher, r); err != nil {
+			return
+		}
+		return algorithm + "-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil)), err
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %q", algorithm)
+}
+
+func validateFileHash(fileName string, expectedHash string) (err error) {
+	r, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	return validateReaderHash(r, expectedHash)
+}
+
+// validateReaderHash verifies the reader against an SRI string, using the strongest supported
+// algorithm present.
+func validateReaderHash(r io.Reader, expectedHash string) (err error) {
+	i, err := parseIntegrity(expectedHash)
+	if err != nil {
+		return err
+	}
+	return i.verify(r)
+}
@@ -0,0 +1,102 @@
+package npm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIntegrity(t *testing.T) {
+	tests := []struct {
+		name        string
+		integrity   string
+		expected    integrity
+		expectedErr bool
+	}{
+		{
+			name:      "single digest",
+			integrity: "sha512-a",
+			expected:  integrity{Algorithm: "sha512", Digests: []string{"a"}},
+		},
+		{
+			name:      "strongest algorithm is selected",
+			integrity: "sha1-a sha256-b sha512-c sha384-d",
+			expected:  integrity{Algorithm: "sha512", Digests: []string{"c"}},
+		},
+		{
+			name:      "multiple digests of the strongest algorithm",
+			integrity: "sha384-a\tsha512-b\nsha512-c",
+			expected:  integrity{Algorithm: "sha512", Digests: []string{"b", "c"}},
+		},
+		{
+			name:      "unknown algorithms and options are ignored",
+			integrity: "sha3-a md5-b sha256-c?foo",
+			expected:  integrity{Algorithm: "sha256", Digests: []string{"c"}},
+		},
+		{
+			name:      "algorithm names aren't case sensitive",
+			integrity: "SHA1-a",
+			expected:  integrity{Algorithm: "sha1", Digests: []string{"a"}},
+		},
+		{
+			name:        "only unknown algorithms",
+			integrity:   "md5-a sha3-b",
+			expectedErr: true,
+		},
+		{
+			name:        "invalid tokens",
+			integrity:   "sha512 sha512- a",
+			expectedErr: true,
+		},
+		{
+			name:        "empty",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := parseIntegrity(test.integrity)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValidateReaderHash(t *testing.T) {
+	// The digests of "a".
+	sha1 := "sha1-hvfkN/qlp/zhXR3cuerq6jd2Z7g="
+	sha512 := "sha512-H0D8ktokFpR1CXnubPWC8tXX0o4YM13gWrxU0FYOD1MChgxlK/CNVgJSql50IQVG82n7u86MEs/HlXsmUv6adQ=="
+	tests := []struct {
+		name        string
+		integrity   string
+		expectedErr bool
+	}{
+		{name: "matching", integrity: sha512},
+		{name: "one of several digests matches", integrity: "sha512-b " + sha512},
+		// Only the strongest algorithm is checked.
+		{name: "weaker algorithm matches", integrity: sha1 + " sha512-b", expectedErr: true},
+		{name: "stronger algorithm matches", integrity: "sha1-b " + sha512},
+		{name: "mismatch", integrity: "sha512-b", expectedErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateReaderHash(strings.NewReader("a"), test.integrity)
+			if test.expectedErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !test.expectedErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package npm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandLockFiles returns the lock files matching the file names and glob patterns, e.g.
+// services/*/package-lock.json or **/package-lock.json, where ** matches any number of
+// directories. node_modules directories are skipped, since they contain the lock files of
+// installed packages.
+func expandLockFiles(patterns []string) (fileNames []string, err error) {
+	seen := make(map[string]struct{})
+	add := func(fileName string) {
+		if _, ok := seen[fileName]; ok {
+			return
+		}
+		seen[fileName] = struct{}{}
+		fileNames = append(fileNames, fileName)
+	}
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(filepath.FromSlash(pattern))
+			continue
+		}
+		root := globRoot(pattern)
+		var matched bool
+		err = filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == "node_modules" || d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if matchGlob(pattern, path.Clean(filepath.ToSlash(p))) {
+				matched = true
+				add(p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find lock files matching %q: %w", pattern, err)
+		}
+		if !matched {
+			return nil, fmt.Errorf("no lock files match %q", pattern)
+		}
+	}
+	return fileNames, nil
+}
+
+// globRoot returns the directory to search for a glob pattern, i.e. the directories before the
+// first wildcard.
+func globRoot(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "*?[") {
+			if i == 0 {
+				return "."
+			}
+			if root := strings.Join(segments[:i], "/"); root != "" {
+				return root
+			}
+			return "/"
+		}
+	}
+	return path.Dir(pattern)
+}
+
+// matchGlob matches a slash separated path against a pattern, where ** matches zero or more
+// directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(path.Clean(pattern), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// mergedPackage is a package to export, and the lock files that contain it.
+type mergedPackage struct {
+	Package
+	LockFiles []string
+}
+
+// mergePackages deduplicates the packages of multiple lock files by name and version, or by the
+// resolved value if the name or version isn't known. Packages with conflicting integrities, i.e.
+// different digests for the same algorithm, are returned as errors rather than being exported,
+// since it's not known which is correct. Integrities with different algorithms can't be compared
+// until the tarball is downloaded, so the strongest is used to download it, and the tarball must
+// match the others too.
+func mergePackages(packages []Package) (merged []*mergedPackage, conflicts []error) {
+	byKey := make(map[string]*mergedPackage)
+	byURL := make(map[string]*mergedPackage)
+	conflicted := make(map[*mergedPackage]bool)
+	for _, pkg := range packages {
+		key := packageKey(pkg)
+		isURL := strings.HasPrefix(pkg.Resolved, "http://") || strings.HasPrefix(pkg.Resolved, "https://")
+		m, ok := byKey[key]
+		if !ok && isURL {
+			// The same tarball URL may be listed under different names, e.g. aliases.
+			m, ok = byURL[pkg.Resolved]
+		}
+		if !ok {
+			m = &mergedPackage{Package: pkg}
+			byKey[key] = m
+			if isURL {
+				byURL[pkg.Resolved] = m
+			}
+			merged = append(merged, m)
+		}
+		if !contains(m.LockFiles, pkg.lockFileName) {
+			m.LockFiles = append(m.LockFiles, pkg.lockFileName)
+		}
+		// Packages from Yarn 2+ lock files are also verified against the yarn cache.
+		if m.yarnArchive == nil {
+			m.yarnArchive = pkg.yarnArchive
+		}
+		if !ok || pkg.Integrity == "" {
+			continue
+		}
+		if m.Integrity == "" {
+			m.Integrity = pkg.Integrity
+			continue
+		}
+		if err := mergeIntegrity(&m.Package, pkg.Integrity); err != nil {
+			if !conflicted[m] {
+				conflicted[m] = true
+				conflicts = append(conflicts, &ExportError{Name: m.Name, Version: m.Version, URL: m.Resolved, Err: fmt.Errorf("%w, lock files: %s", err, strings.Join(m.LockFiles, ", "))})
+			}
+		}
+	}
+	// Drop the conflicting packages.
+	n := 0
+	for _, m := range merged {
+		if !conflicted[m] {
+			merged[n] = m
+			n++
+		}
+	}
+	return merged[:n], conflicts
+}
+
+// packageKey identifies the tarball of a package across lock files. The integrity isn't part of
+// the key, since the tarball is stored under its name and version, so lock files that disagree
+// about it are merged by mergeIntegrity, and reported as a conflict if they can't all match.
+func packageKey(pkg Package) string {
+	switch {
+	case isLocalDependency(pkg):
+		return localPath(pkg)
+	case pkg.Name == "" || pkg.Version == "" || isGitDependency(pkg.Resolved):
+		return pkg.Resolved
+	}
+	return pkg.Name + "@" + pkg.Version
+}
+
+// mergeIntegrity adds an integrity from another lock file to the package. The strongest
+// integrity is kept in Integrity, and the others with different algorithms are kept in
+// otherIntegrities, so that the tarball is verified against all of them.
+func mergeIntegrity(pkg *Package, other string) error {
+	all := append([]string{pkg.Integrity}, pkg.otherIntegrities...)
+	for _, existing := range all {
+		if _, err := strongerIntegrity(existing, other); err != nil {
+			return err
+		}
+	}
+	stronger, _ := strongerIntegrity(pkg.Integrity, other)
+	weaker := other
+	if stronger == other {
+		weaker = pkg.Integrity
+	}
+	pkg.Integrity = stronger
+	// Integrities with the same algorithm as the stronger one have a matching digest.
+	si, err := parseIntegrity(stronger)
+	if err != nil {
+		return nil
+	}
+	if wi, err := parseIntegrity(weaker); err == nil && wi.Algorithm != si.Algorithm && !contains(pkg.otherIntegrities, weaker) {
+		pkg.otherIntegrities = append(pkg.otherIntegrities, weaker)
+	}
+	return nil
+}
+
+// strongerIntegrity returns the integrity with the strongest algorithm, or an error if both
+// use the same algorithm and none of the digests match.
+func strongerIntegrity(a, b string) (stronger string, err error) {
+	ai, err := parseIntegrity(a)
+	if err != nil {
+		return b, nil
+	}
+	bi, err := parseIntegrity(b)
+	if err != nil {
+		return a, nil
+	}
+	if ai.Algorithm != bi.Algorithm {
+		for _, ha := range hashAlgorithms {
+			switch ha.Name {
+			case ai.Algorithm:
+				return a, nil
+			case bi.Algorithm:
+				return b, nil
+			}
+		}
+	}
+	for _, d := range ai.Digests {
+		if contains(bi.Digests, d) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("conflicting integrity %q and %q", a, b)
+}
+
+// writeLockFileReport writes the lock files that contributed each package to fileName, keyed
+// by <name>@<version>, or the resolved value for packages without a name or version.
+func writeLockFileReport(fileName string, merged []*mergedPackage) error {
+	report := make(map[string][]string, len(merged))
+	for _, m := range merged {
+		key := m.Resolved
+		if m.Name != "" && m.Version != "" {
+			key = m.Name + "@" + m.Version
+		}
+		lockFiles := append(report[key], m.LockFiles...)
+		sort.Strings(lockFiles)
+		report[key] = lockFiles
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(data, '\n'), 0660)
+}
@@ -0,0 +1,223 @@
+package npm
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergePackages(t *testing.T) {
+	sha512Of := func(s string) string {
+		sum := sha512.Sum512([]byte(s))
+		return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	sha1Of := func(s string) string {
+		sum := sha1.Sum([]byte(s))
+		return "sha1-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	pkg := func(lockFileName, integrity string) Package {
+		return Package{
+			Name:         "a",
+			Version:      "1.0.0",
+			Resolved:     "https://registry.npmjs.org/a/-/a-1.0.0.tgz",
+			Integrity:    integrity,
+			lockFileName: lockFileName,
+		}
+	}
+	tests := []struct {
+		name                     string
+		packages                 []Package
+		expectedIntegrity        string
+		expectedOtherIntegrities []string
+		expectedConflict         bool
+	}{
+		{
+			name:              "same integrity",
+			packages:          []Package{pkg("a/package-lock.json", sha512Of("a")), pkg("b/package-lock.json", sha512Of("a"))},
+			expectedIntegrity: sha512Of("a"),
+		},
+		{
+			name:              "missing integrity",
+			packages:          []Package{pkg("a/package-lock.json", ""), pkg("b/package-lock.json", sha512Of("a"))},
+			expectedIntegrity: sha512Of("a"),
+		},
+		{
+			name:                     "different algorithms are all verified",
+			packages:                 []Package{pkg("a/package-lock.json", sha1Of("a")), pkg("b/package-lock.json", sha512Of("a"))},
+			expectedIntegrity:        sha512Of("a"),
+			expectedOtherIntegrities: []string{sha1Of("a")},
+		},
+		{
+			name:              "matching digest of multiple digests",
+			packages:          []Package{pkg("a/package-lock.json", sha512Of("a")+" "+sha512Of("b")), pkg("b/package-lock.json", sha512Of("b"))},
+			expectedIntegrity: sha512Of("a") + " " + sha512Of("b"),
+		},
+		{
+			name:             "conflicting digests",
+			packages:         []Package{pkg("a/package-lock.json", sha512Of("a")), pkg("b/package-lock.json", sha512Of("b"))},
+			expectedConflict: true,
+		},
+		{
+			name:             "conflicting weaker digests",
+			packages:         []Package{pkg("a/package-lock.json", sha1Of("a")), pkg("b/package-lock.json", sha512Of("a")), pkg("c/package-lock.json", sha1Of("b"))},
+			expectedConflict: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts := mergePackages(test.packages)
+			if test.expectedConflict {
+				if len(conflicts) != 1 || len(merged) != 0 {
+					t.Fatalf("expected a conflict, got %d merged packages and conflicts %v", len(merged), conflicts)
+				}
+				return
+			}
+			if len(conflicts) != 0 {
+				t.Fatalf("unexpected conflicts: %v", conflicts)
+			}
+			if len(merged) != 1 {
+				t.Fatalf("expected 1 merged package, got %d", len(merged))
+			}
+			m := merged[0]
+			if m.Integrity != test.expectedIntegrity {
+				t.Errorf("expected integrity %q, got %q", test.expectedIntegrity, m.Integrity)
+			}
+			if !reflect.DeepEqual(m.otherIntegrities, test.expectedOtherIntegrities) {
+				t.Errorf("expected other integrities %q, got %q", test.expectedOtherIntegrities, m.otherIntegrities)
+			}
+			if len(m.LockFiles) != len(test.packages) {
+				t.Errorf("expected %d lock files, got %q", len(test.packages), m.LockFiles)
+			}
+		})
+	}
+}
+
+func TestExportMergedYarnPackage(t *testing.T) {
+	sha512Of := func(b []byte) string {
+		sum := sha512.Sum512(b)
+		return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	tarball, err := os.ReadFile(writeTarball(t, t.TempDir(), "a", "1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := http.NewServeMux()
+	upstream.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"name":"a","versions":{"1.0.0":{"name":"a","version":"1.0.0","dist":{"integrity":%q}}}}`, sha512Of(tarball))
+	})
+	upstream.HandleFunc("/a/-/a-1.0.0.tgz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tarball)
+	})
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	r := &registry{url: server.URL, client: http.DefaultClient}
+
+	tests := []struct {
+		name string
+		// lockFiles are read in order.
+		lockFiles []string
+		// archiveManifest is the package.json in the yarn cache archive.
+		archiveManifest string
+		// integrity is the integrity in package-lock.json.
+		integrity   string
+		expectedErr bool
+	}{
+		{
+			name:            "matching integrity",
+			lockFiles:       []string{"yarn.lock", "package-lock.json"},
+			archiveManifest: `{"name":"a","version":"1.0.0","description":"test"}`,
+			integrity:       sha512Of(tarball),
+		},
+		{
+			name:            "matching integrity, package-lock.json first",
+			lockFiles:       []string{"package-lock.json", "yarn.lock"},
+			archiveManifest: `{"name":"a","version":"1.0.0","description":"test"}`,
+			integrity:       sha512Of(tarball),
+		},
+		{
+			name:            "package-lock.json integrity mismatch",
+			lockFiles:       []string{"yarn.lock", "package-lock.json"},
+			archiveManifest: `{"name":"a","version":"1.0.0","description":"test"}`,
+			integrity:       sha512Of([]byte("other")),
+			expectedErr:     true,
+		},
+		{
+			name:            "package-lock.json integrity mismatch, package-lock.json first",
+			lockFiles:       []string{"package-lock.json", "yarn.lock"},
+			archiveManifest: `{"name":"a","version":"1.0.0","description":"test"}`,
+			integrity:       sha512Of([]byte("other")),
+			expectedErr:     true,
+		},
+		{
+			name:            "yarn cache archive mismatch, package-lock.json first",
+			lockFiles:       []string{"package-lock.json", "yarn.lock"},
+			archiveManifest: `{"name":"a","version":"1.0.0","description":"modified"}`,
+			integrity:       sha512Of(tarball),
+			expectedErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			checksum := writeYarnArchive(t, filepath.Join(dir, ".yarn", "cache"), "a", "1.0.0", map[string]string{"package.json": test.archiveManifest})
+			yarnLock := fmt.Sprintf("__metadata:\n  version: 8\n  cacheKey: 10c0\n\n\"a@npm:^1.0.0\":\n  version: 1.0.0\n  resolution: \"a@npm:1.0.0\"\n  checksum: 10c0/%s\n  languageName: node\n  linkType: hard\n", checksum)
+			if err := os.WriteFile(filepath.Join(dir, "yarn.lock"), []byte(yarnLock), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			packageLock := fmt.Sprintf(`{"lockfileVersion":3,"packages":{"":{"dependencies":{"a":"^1.0.0"}},"node_modules/a":{"version":"1.0.0","resolved":"%s/a/-/a-1.0.0.tgz","integrity":%q}}}`, server.URL, test.integrity)
+			if err := os.WriteFile(filepath.Join(dir, "package-lock.json"), []byte(packageLock), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var packages []Package
+			for _, lockFileName := range test.lockFiles {
+				lockFile, err := readLockFile(filepath.Join(dir, lockFileName), r)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for key, pkg := range lockFile.Packages {
+					if key != "" {
+						packages = append(packages, pkg)
+					}
+				}
+			}
+			merged, conflicts := mergePackages(packages)
+			if len(conflicts) != 0 || len(merged) != 1 {
+				t.Fatalf("expected 1 merged package, got %d and conflicts %v", len(merged), conflicts)
+			}
+
+			// Packages are exported relative to the working directory.
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			exported, _, err := exportPackage(r, merged[0].Package)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if _, err = os.Stat("package/npm/a/a-1.0.0.tgz"); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected the tarball to be deleted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err = os.Stat(exported.FileName); err != nil {
+				t.Errorf("expected the tarball to be exported, got %v", err)
+			}
+		})
+	}
+}
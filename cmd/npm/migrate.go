@@ -0,0 +1,67 @@
+package npm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"log/slog"
+)
+
+// migrateOutputDirectory moves tarballs from the flat layout used by earlier versions of impex,
+// i.e. package/npm/<name>-<version>.tgz, to package/npm/<name>/<name>-<version>.tgz.
+// The package name and version are read from the package.json inside each tarball, since the
+// flat file name doesn't include the scope.
+func migrateOutputDirectory(dir string, log *slog.Logger) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tgz") {
+			continue
+		}
+		from := filepath.Join(dir, entry.Name())
+		to, err := migratedFileName(dir, from)
+		if err != nil {
+			// Leave the file, it will be downloaded again if it's still required.
+			log.Warn("Unable to migrate tarball", slog.String("file", from), slog.Any("error", err))
+			continue
+		}
+		if _, err = os.Stat(to); err == nil {
+			log.Info("Removing migrated tarball, already exists", slog.String("from", from), slog.String("to", to))
+			if err = os.Remove(from); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Info("Migrating tarball", slog.String("from", from), slog.String("to", to))
+		if err = os.MkdirAll(filepath.Dir(to), 0770); err != nil {
+			return err
+		}
+		if err = os.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to move %q to %q: %w", from, to, err)
+		}
+	}
+	return nil
+}
+
+func migratedFileName(dir, fileName string) (to string, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := readPackageJSON(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if !isValidPackageName(name) || !isValidSemver(version) {
+		return "", fmt.Errorf("invalid package name %q or version %q in package.json", name, version)
+	}
+	return filepath.Join(dir, filepath.FromSlash(name), path.Base(name)+"-"+version+".tgz"), nil
+}
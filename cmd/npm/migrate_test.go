@@ -0,0 +1,52 @@
+package npm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"log/slog"
+)
+
+func TestMigrateOutputDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// writeFlatTarball writes a tarball in the flat layout, i.e. without the scope.
+	writeFlatTarball := func(name, version, flatName string) {
+		fileName := writeTarball(t, t.TempDir(), name, version)
+		if err := os.Rename(fileName, filepath.Join(dir, flatName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFlatTarball("@scope/name", "1.0.0", "name-1.0.0.tgz")
+	writeFlatTarball("a", "2.0.0", "a-2.0.0.tgz")
+	// Already migrated, e.g. by an interrupted run.
+	writeFlatTarball("b", "3.0.0", "b-3.0.0.tgz")
+	writeTarball(t, dir, "b", "3.0.0")
+	if err := os.WriteFile(filepath.Join(dir, "invalid.tgz"), []byte("not a tarball"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateOutputDirectory(dir, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, fileName := range []string{"@scope/name/name-1.0.0.tgz", "a/a-2.0.0.tgz", "b/b-3.0.0.tgz", "invalid.tgz"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(fileName))); err != nil {
+			t.Errorf("expected %s to exist, got %v", fileName, err)
+		}
+	}
+	for _, fileName := range []string{"name-1.0.0.tgz", "a-2.0.0.tgz", "b-3.0.0.tgz"} {
+		if _, err := os.Stat(filepath.Join(dir, fileName)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be moved, got %v", fileName, err)
+		}
+	}
+	exported, err := readExportedPackage(filepath.Join(dir, "@scope", "name", "name-1.0.0.tgz"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Name != "@scope/name" || exported.Version != "1.0.0" {
+		t.Errorf("expected @scope/name@1.0.0, got %s@%s", exported.Name, exported.Version)
+	}
+}
@@ -1,8 +1,6 @@
 package npm
 
 import (
-	"crypto/sha512"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +9,8 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,22 +19,88 @@ import (
 )
 
 type NPMLock struct {
-	Name     string             `json:"name"`
-	Version  string             `json:"version"`
-	Packages map[string]Package `json:"packages"`
+	Name            string             `json:"name"`
+	Version         string             `json:"version"`
+	LockfileVersion int                `json:"lockfileVersion"`
+	Packages        map[string]Package `json:"packages"`
+	// Dependencies is the nested dependency tree used by lockfileVersion 1.
+	// lockfileVersion 2 includes it for backwards compatibility, and lockfileVersion 3 omits it.
+	Dependencies map[string]Dependency `json:"dependencies,omitempty"`
+}
+
+// Dependency is a node in the lockfileVersion 1 dependency tree.
+type Dependency struct {
+	Version      string                `json:"version"`
+	Resolved     string                `json:"resolved"`
+	Integrity    string                `json:"integrity"`
+	Bundled      bool                  `json:"bundled"`
+	Dev          bool                  `json:"dev"`
+	Optional     bool                  `json:"optional"`
+	Requires     map[string]string     `json:"requires"`
+	Dependencies map[string]Dependency `json:"dependencies"`
 }
 
 type Package struct {
-	Name         string            `json:"name"`
-	Version      string            `json:"version"`
-	Resolved     string            `json:"resolved"`
-	Integrity    string            `json:"integrity"`
-	Dependencies map[string]string `json:"dependencies"`
+	Name         string            `json:"name,omitempty"`
+	Version      string            `json:"version,omitempty"`
+	Resolved     string            `json:"resolved,omitempty"`
+	Integrity    string            `json:"integrity,omitempty"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+	Optional     bool              `json:"optional,omitempty"`
+	Peer         bool              `json:"peer,omitempty"`
+	Os           []string          `json:"os,omitempty"`
+	Cpu          []string          `json:"cpu,omitempty"`
+	Libc         []string          `json:"libc,omitempty"`
+	// DevOptional is set in lockfileVersion 2 and 3 for packages that are only required by
+	// optional dependencies of dev dependencies, or dev dependencies of optional dependencies.
+	DevOptional bool `json:"devOptional,omitempty"`
+	// Link is set for workspaces and file: dependencies on directories, where Resolved is the
+	// path of the directory relative to the lock file.
+	Link bool `json:"link,omitempty"`
+
+	// lockFileName is the lock file the package was read from. Local dependencies are relative
+	// to its directory.
+	lockFileName string
+	// yarnArchive is set for registry packages of Yarn 2+ lock files, whose checksum is of the
+	// archive in the yarn cache rather than the tarball.
+	yarnArchive *yarnArchive
+	// otherIntegrities are integrities from other lock files, with weaker algorithms than
+	// Integrity, that the tarball must also match.
+	otherIntegrities []string
 }
 
 type Arguments struct {
-	FileName string
-	Log      *slog.Logger
+	// FileNames of the lock files, either package-lock.json, yarn.lock or pnpm-lock.yaml. Glob
+	// patterns are expanded, e.g. **/package-lock.json, skipping node_modules directories. The
+	// packages of all of the lock files are exported.
+	FileNames []string
+	// Packages are specs to resolve from the registry instead of, or as well as, the lock file,
+	// e.g. typescript@5, react@^18.2.0 or eslint.
+	Packages []string
+	// OutputLockFileName is where the lock file of the resolved Packages is written.
+	// Defaults to package/npm/package-lock.json
+	OutputLockFileName string
+	// Platform limits the optional packages that are exported, e.g. to skip native binaries
+	// for other operating systems.
+	Platform Platform
+	// Registry is the base URL of the registry used for lock files that don't include tarball
+	// URLs or integrity. Defaults to the registry in .npmrc, or https://registry.npmjs.org
+	Registry string
+	// NPMRC is the path to an .npmrc file containing registry credentials. The project .npmrc
+	// next to the lock file, and the user's ~/.npmrc are also read.
+	NPMRC string
+	// Concurrency is the number of packages to download at the same time. Defaults to 4.
+	Concurrency int
+	// Timeout is the maximum duration of each registry request, including reading the response.
+	// Defaults to 5 minutes.
+	Timeout time.Duration
+	// Retries is the number of times to retry a request that fails with a server error, rate
+	// limiting, or a network error.
+	Retries int
+	// RetryDelay is the wait before the first retry, which doubles for each retry. The
+	// Retry-After header is used instead if the registry sends one. Defaults to 1 second.
+	RetryDelay time.Duration
+	Log        *slog.Logger
 }
 
 func Run(args Arguments) error {
@@ -46,11 +112,77 @@ func Run(args Arguments) error {
 		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	}
 
-	// Parse the lock file.
-	log.Info("Parsing lock file")
-	lockFile, err := parseLockFile(args.FileName)
+	// Find the lock files.
+	lockFileNames, err := expandLockFiles(args.FileNames)
+	if err != nil {
+		return err
+	}
+
+	// Read registry configuration and credentials.
+	rc, err := loadNpmrc(args.NPMRC, npmrcFileNames(args.NPMRC, lockFileNames...)...)
 	if err != nil {
-		return fmt.Errorf("failed to parse lock file: %w", err)
+		return err
+	}
+	registryURL := args.Registry
+	if registryURL == "" {
+		registryURL = rc.Registry
+	}
+	if registryURL == "" {
+		registryURL = defaultRegistryURL
+	}
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	retryDelay := args.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = time.Second
+	}
+	r := &registry{
+		url:    strings.TrimSuffix(registryURL, "/"),
+		client: &http.Client{Timeout: timeout},
+		retry: retryPolicy{
+			Retries:  args.Retries,
+			Delay:    retryDelay,
+			MaxDelay: time.Minute,
+			Log:      log,
+		},
+		npmrc: rc,
+	}
+
+	// Parse the lock files.
+	var packagesToExport []Package
+	for _, fileName := range lockFileNames {
+		log.Info("Parsing lock file", slog.String("file", fileName))
+		lockFile, err := readLockFile(fileName, r)
+		if err != nil {
+			return fmt.Errorf("failed to parse lock file %q: %w", fileName, err)
+		}
+		for _, pkg := range lockFile.Packages {
+			packagesToExport = append(packagesToExport, pkg)
+		}
+	}
+
+	// Resolve packages without a lock file, and write the lock file for reproducibility.
+	if len(args.Packages) > 0 {
+		log.Info("Resolving packages", slog.Any("packages", args.Packages))
+		lockFile, err := resolvePackages(r, args.Packages, log)
+		if err != nil {
+			return fmt.Errorf("failed to resolve packages: %w", err)
+		}
+		outputLockFileName := args.OutputLockFileName
+		if outputLockFileName == "" {
+			outputLockFileName = "package/npm/package-lock.json"
+		}
+		log.Info("Writing lock file", slog.String("file", outputLockFileName), slog.Int("packages", len(lockFile.Packages)-1))
+		if err = writeLockFile(outputLockFileName, lockFile); err != nil {
+			return fmt.Errorf("failed to write lock file: %w", err)
+		}
+		setPackageNames(lockFile.Packages)
+		for _, pkg := range lockFile.Packages {
+			pkg.lockFileName = outputLockFileName
+			packagesToExport = append(packagesToExport, pkg)
+		}
 	}
 
 	// Create output directory if required.
@@ -58,55 +190,77 @@ func Run(args Arguments) error {
 		return err
 	}
 
+	// Move tarballs exported by earlier versions of impex into the current layout.
+	if err = migrateOutputDirectory("package/npm", log); err != nil {
+		return fmt.Errorf("failed to migrate output directory: %w", err)
+	}
+
 	// Download targz files into directory.
 	// Create the packages channel.
 	packages := make(chan Package)
 	var downloadsCompleted int64
 	var fromCache int64
+	var resultsMutex sync.Mutex
+	var exported []exportedPackage
+	var failures []error
 
 	// Drain the channel concurrently.
-	concurrency := 4
+	concurrency := args.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
 	var wg sync.WaitGroup
-	errors := make([]error, concurrency)
+	// Add before starting the workers, otherwise Wait can return before they've started.
+	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
-		go func(i int) {
-			wg.Add(1)
+		go func() {
 			defer wg.Done()
 			for pkg := range packages {
-				targetFileName, err := getFileName(pkg.Resolved)
+				result, cached, err := exportPackage(r, pkg)
+				resultsMutex.Lock()
 				if err != nil {
-					errors[i] = err
-					break
+					failures = append(failures, &ExportError{Name: pkg.Name, Version: pkg.Version, URL: pkg.Resolved, Err: err})
+				} else {
+					exported = append(exported, result)
 				}
-				if isAlreadyDownloaded(targetFileName, pkg.Integrity) {
+				resultsMutex.Unlock()
+				switch {
+				case err != nil:
+				case cached:
 					atomic.AddInt64(&fromCache, 1)
-					continue
-				}
-				err = download(pkg.Resolved, targetFileName, pkg.Integrity)
-				if err != nil {
-					errors[i] = err
+				default:
+					atomic.AddInt64(&downloadsCompleted, 1)
 				}
-				atomic.AddInt64(&downloadsCompleted, 1)
 			}
-		}(i)
+		}()
 	}
 
 	// Filter the packages.
 	var resolvedPackages []Package
-	for _, pkg := range lockFile.Packages {
+	for _, pkg := range packagesToExport {
 		// If there's no URL, skip.
 		if pkg.Resolved == "" {
 			continue
 		}
+		// Skip optional packages for other platforms.
+		if !args.Platform.includes(pkg) {
+			log.Info("Skipping optional package for other platform", slog.String("name", pkg.Name), slog.String("version", pkg.Version), slog.Any("os", pkg.Os), slog.Any("cpu", pkg.Cpu), slog.Any("libc", pkg.Libc))
+			continue
+		}
 		resolvedPackages = append(resolvedPackages, pkg)
 	}
-	downloadsTotal := len(resolvedPackages)
+
+	// The same tarball can appear multiple times, e.g. nested in different node_modules, or in
+	// multiple lock files.
+	merged, conflicts := mergePackages(resolvedPackages)
+	failures = append(failures, conflicts...)
+	downloadsTotal := len(merged)
 
 	// Push data into the channel, and notify when complete.
 	done := make(chan struct{}, 1)
 	go func() {
-		for _, pkg := range resolvedPackages {
-			packages <- pkg
+		for _, m := range merged {
+			packages <- m.Package
 		}
 		close(packages)
 		done <- struct{}{}
@@ -127,59 +281,165 @@ exit:
 	}
 	wg.Wait()
 
-	log.Info("Complete", slog.Int("total", downloadsTotal), slog.Int("downloads", int(downloadsCompleted)), slog.Int("fromCache", int(fromCache)), slog.String("duration", time.Now().Sub(start).String()))
+	// Write registry metadata.
+	log.Info("Writing packuments", slog.Int("total", len(exported)))
+	if err = writePackuments("package/npm", r, exported, log); err != nil {
+		return err
+	}
+	if err = writeSources("package/npm", exported); err != nil {
+		return fmt.Errorf("failed to write sources: %w", err)
+	}
+	if err = writeLockFileReport("package/npm/lock-files.json", merged); err != nil {
+		return fmt.Errorf("failed to write lock file report: %w", err)
+	}
+
+	// Summarise failures.
+	for _, err := range failures {
+		var exportErr *ExportError
+		if errors.As(err, &exportErr) {
+			log.Error("Failed", slog.String("name", exportErr.Name), slog.String("version", exportErr.Version), slog.String("url", exportErr.URL), slog.String("error", exportErr.Err.Error()))
+		}
+	}
+
+	log.Info("Complete", slog.Int("total", downloadsTotal), slog.Int("downloads", int(downloadsCompleted)), slog.Int("fromCache", int(fromCache)), slog.Int("failed", len(failures)), slog.String("duration", time.Now().Sub(start).String()))
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d packages failed to export:\n%w", len(failures), downloadsTotal+len(conflicts), errors.Join(failures...))
+	}
 	return nil
 }
 
-func getFileName(from string) (fileName string, err error) {
-	u, err := url.Parse(from)
+// ExportError is returned when a package fails to download or verify.
+type ExportError struct {
+	Name    string
+	Version string
+	URL     string
+	Err     error
+}
+
+func (e *ExportError) Error() string {
+	return fmt.Sprintf("%s@%s (%s): %v", e.Name, e.Version, e.URL, e.Err)
+}
+
+func (e *ExportError) Unwrap() error {
+	return e.Err
+}
+
+// exportPackage downloads the package into the output directory, unless it has already been
+// downloaded. Git and local dependencies are packed into tarballs. cached is true if the
+// existing file was used.
+func exportPackage(r *registry, pkg Package) (exported exportedPackage, cached bool, err error) {
+	switch {
+	case isGitDependency(pkg.Resolved):
+		return exportGitPackage(pkg)
+	case isLocalDependency(pkg):
+		exported, err = exportLocalPackage(pkg)
+		return exported, false, err
+	}
+	targetFileName, err := getFileName(pkg)
 	if err != nil {
-		return fileName, err
+		return exported, false, err
 	}
-	_, name := path.Split(u.Path)
-	if name == "" {
-		return fileName, fmt.Errorf("no filename present in path %q for URL %q", u.Path, from)
+	exported = exportedPackage{Name: pkg.Name, Version: pkg.Version, FileName: targetFileName}
+	integrities := pkg.otherIntegrities
+	if pkg.yarnArchive != nil {
+		if cached, err = exportYarnBerryPackage(r, pkg, targetFileName); err != nil {
+			return exported, false, err
+		}
+		// The integrity is from another lock file that the package was merged with, since yarn
+		// lock files only have the checksum of the archive.
+		if pkg.Integrity != "" {
+			integrities = append([]string{pkg.Integrity}, integrities...)
+		}
+	} else {
+		if pkg.Integrity == "" {
+			pkg.Integrity, err = r.getIntegrity(pkg.Name, pkg.Version)
+			if err != nil {
+				return exported, false, err
+			}
+		}
+		cached = isAlreadyDownloaded(targetFileName, pkg.Integrity)
+		if !cached {
+			if err = download(r, pkg.Resolved, targetFileName, pkg.Integrity); err != nil {
+				return exported, false, err
+			}
+		}
 	}
-	return path.Join("package/npm", name), nil
+	for _, integrity := range integrities {
+		if err = validateFileHash(targetFileName, integrity); err != nil {
+			// Delete the tarball, so that it's not used by the next run, or imported.
+			os.Remove(targetFileName)
+			return exported, false, fmt.Errorf("tarball doesn't match the integrity %q from another lock file: %w", integrity, err)
+		}
+	}
+	return exported, cached, nil
 }
 
-func hashFile(name string) (hash string, err error) {
-	r, err := os.Open(name)
-	if err != nil {
-		return
+// getFileName returns the path of the tarball in the output directory. Tarballs are stored as
+// package/npm/<name>/<name>-<version>.tgz, including the scope, e.g.
+// package/npm/@babel/core/core-7.0.0.tgz, so that packages with the same name in different
+// scopes don't overwrite each other.
+func getFileName(pkg Package) (fileName string, err error) {
+	name, version := pkg.Name, pkg.Version
+	if name == "" || !isValidSemver(version) {
+		// Fall back to the registry tarball URL convention.
+		urlName, tarball, err := parseTarballURL(pkg.Resolved)
+		if err != nil {
+			return fileName, err
+		}
+		return outputFileName(urlName, tarball)
 	}
-	defer r.Close()
-	return hashReader(r)
+	return outputFileName(name, path.Base(name)+"-"+version+".tgz")
 }
 
-func hashReader(r io.Reader) (hash string, err error) {
-	hasher := sha512.New()
-	if _, err = io.Copy(hasher, r); err != nil {
-		return
+// parseTarballURL returns the package name and tarball file name from a registry tarball URL,
+// i.e. /<name>/-/<name>-<version>.tgz
+func parseTarballURL(resolved string) (name, tarball string, err error) {
+	u, err := url.Parse(resolved)
+	if err != nil {
+		return "", "", err
+	}
+	// Registries can be hosted under a path, e.g. /repository/npm/, so use the last /-/
+	i := strings.LastIndex(u.Path, "/-/")
+	if i < 0 {
+		return "", "", fmt.Errorf("unable to determine the package name and version of %q", resolved)
 	}
-	return "sha512-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil)), err
+	segments := strings.Split(strings.Trim(u.Path[:i], "/"), "/")
+	name, tarball = segments[len(segments)-1], u.Path[i+len("/-/"):]
+	if len(segments) > 1 && strings.HasPrefix(segments[len(segments)-2], "@") {
+		name = segments[len(segments)-2] + "/" + name
+	}
+	if name == "" || tarball == "" || strings.Contains(tarball, "/") {
+		return "", "", fmt.Errorf("unable to determine the package name and version of %q", resolved)
+	}
+	return name, tarball, nil
 }
 
-func validateFileHash(fileName string, expectedHash string) (err error) {
-	actualHash, err := hashFile(fileName)
-	if err != nil {
-		return err
+func outputFileName(name, tarball string) (fileName string, err error) {
+	if !isValidPackageName(name) {
+		return fileName, fmt.Errorf("invalid package name %q", name)
 	}
-	if expectedHash != actualHash {
-		return fmt.Errorf("expected hash %q, got %q", expectedHash, actualHash)
+	if strings.ContainsAny(tarball, "/\\") || tarball == ".." {
+		return fileName, fmt.Errorf("invalid tarball name %q", tarball)
 	}
-	return nil
+	return path.Join("package/npm", name, tarball), nil
 }
 
-func validateReaderHash(r io.Reader, expectedHash string) (err error) {
-	actualHash, err := hashReader(r)
-	if err != nil {
-		return err
+// isValidPackageName returns true if the name is a single, optionally scoped, path segment,
+// so that it can't be used to write outside of the output directory.
+func isValidPackageName(name string) bool {
+	parts := strings.Split(name, "/")
+	if len(parts) == 2 && !strings.HasPrefix(parts[0], "@") {
+		return false
 	}
-	if expectedHash != actualHash {
-		return fmt.Errorf("expected hash %q, got %q", expectedHash, actualHash)
+	if len(parts) > 2 {
+		return false
 	}
-	return nil
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, "\\") {
+			return false
+		}
+	}
+	return true
 }
 
 func isAlreadyDownloaded(targetFileName, expectedHash string) bool {
@@ -193,38 +453,50 @@ func isAlreadyDownloaded(targetFileName, expectedHash string) bool {
 	return false
 }
 
-func download(from, targetFileName, expectedHash string) (err error) {
-	// Download the file.
-	resp, err := http.Get(from)
+// download the file, retrying transient failures, including connections that drop part way
+// through the download.
+func download(r *registry, from, targetFileName, expectedHash string) (err error) {
+	return r.retry.do(from, func() error {
+		return downloadOnce(r, from, targetFileName, expectedHash)
+	})
+}
+
+func downloadOnce(r *registry, from, targetFileName, expectedHash string) (err error) {
+	// Download the file, using the registry credentials.
+	req, err := http.NewRequest(http.MethodGet, from, nil)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected status OK for %q, but got %d", from, resp.StatusCode)
+	r.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return err
 	}
 
 	// Create the target.
-	w, err := os.Create(targetFileName)
-	if err != nil {
+	if err = os.MkdirAll(path.Dir(targetFileName), 0770); err != nil {
 		return err
 	}
-
-	// Hash the file while we download it.
-	hashR, hashW := io.Pipe()
-	var writeErr error
-	go func() {
-		_, writeErr = io.Copy(io.MultiWriter(w, hashW), resp.Body)
-		hashW.Close()
-	}()
-	err = validateReaderHash(hashR, expectedHash)
+	w, err := os.Create(targetFileName)
 	if err != nil {
 		return err
 	}
-	if writeErr != nil {
-		return writeErr
-	}
+	// Delete partial or invalid files, so that they're not left in the output directory.
+	defer func() {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(targetFileName)
+		}
+	}()
 
-	return err
+	// Hash the file while we download it.
+	return validateReaderHash(io.TeeReader(resp.Body, w), expectedHash)
 }
 
 func createOutputDirectory() error {
@@ -234,11 +506,74 @@ func createOutputDirectory() error {
 	return nil
 }
 
+// readLockFile parses the lock file, using the file name to determine the format.
+func readLockFile(fileName string, r *registry) (lockFile NPMLock, err error) {
+	switch path.Base(filepath.ToSlash(fileName)) {
+	case "yarn.lock":
+		lockFile, err = parseYarnLockFile(fileName, r)
+	case "pnpm-lock.yaml":
+		lockFile, err = parsePnpmLockFile(fileName, r)
+	default:
+		lockFile, err = parseLockFile(fileName)
+	}
+	// Local dependencies are relative to the lock file.
+	for key, pkg := range lockFile.Packages {
+		pkg.lockFileName = fileName
+		lockFile.Packages[key] = pkg
+	}
+	return lockFile, err
+}
+
 func parseLockFile(fileName string) (lockFile NPMLock, err error) {
 	f, err := os.Open(fileName)
 	if err != nil {
 		return
 	}
-	err = json.NewDecoder(f).Decode(&lockFile)
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&lockFile); err != nil {
+		return
+	}
+	// lockfileVersion 1 files don't have a packages map, so build one from the dependency tree.
+	if lockFile.LockfileVersion < 2 && len(lockFile.Packages) == 0 {
+		lockFile.Packages = make(map[string]Package)
+		flattenDependencies("", lockFile.Dependencies, lockFile.Packages)
+	}
+	setPackageNames(lockFile.Packages)
 	return
 }
+
+// setPackageNames sets the name of each package from its node_modules path, since the name is
+// only present in the packages map when it differs from the path, e.g. aliases.
+func setPackageNames(packages map[string]Package) {
+	for key, pkg := range packages {
+		if pkg.Name != "" {
+			continue
+		}
+		if i := strings.LastIndex(key, "node_modules/"); i >= 0 {
+			pkg.Name = key[i+len("node_modules/"):]
+			packages[key] = pkg
+		}
+	}
+}
+
+// flattenDependencies walks the nested lockfileVersion 1 dependency tree, adding each node
+// to packages with the same node_modules path key that lockfileVersion 2 and 3 use.
+func flattenDependencies(parent string, deps map[string]Dependency, packages map[string]Package) {
+	for name, dep := range deps {
+		key := path.Join(parent, "node_modules", name)
+		// Git and file: dependencies store the source in the version, rather than resolved.
+		resolved := dep.Resolved
+		if resolved == "" && (isGitDependency(dep.Version) || strings.HasPrefix(dep.Version, "file:")) {
+			resolved = dep.Version
+		}
+		packages[key] = Package{
+			Name:         name,
+			Version:      dep.Version,
+			Resolved:     resolved,
+			Integrity:    dep.Integrity,
+			Dependencies: dep.Requires,
+			Optional:     dep.Optional,
+		}
+		flattenDependencies(key, dep.Dependencies, packages)
+	}
+}
@@ -0,0 +1,211 @@
+package npm
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	tarball := []byte("the content of the tarball")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	tests := []struct {
+		name string
+		// responses are used for each request in turn, and the last is repeated. Each is one of
+		// ok, truncated, wrong or unavailable.
+		responses        []string
+		expectedRequests int32
+		expectedErr      bool
+	}{
+		{
+			name:             "complete",
+			responses:        []string{"ok"},
+			expectedRequests: 1,
+		},
+		{
+			name:             "truncated body is retried",
+			responses:        []string{"truncated", "ok"},
+			expectedRequests: 2,
+		},
+		{
+			name:             "truncated body",
+			responses:        []string{"truncated"},
+			expectedRequests: 3,
+			expectedErr:      true,
+		},
+		{
+			name:             "server error",
+			responses:        []string{"unavailable"},
+			expectedRequests: 3,
+			expectedErr:      true,
+		},
+		{
+			// Retrying won't change the content of the tarball.
+			name:             "wrong bytes aren't retried",
+			responses:        []string{"wrong", "ok"},
+			expectedRequests: 1,
+			expectedErr:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1)) - 1
+				switch test.responses[min(n, len(test.responses)-1)] {
+				case "ok":
+					w.Write(tarball)
+				case "truncated":
+					// The connection is closed before the declared length is written.
+					w.Header().Set("Content-Length", strconv.Itoa(len(tarball)))
+					w.Write(tarball[:len(tarball)/2])
+				case "wrong":
+					w.Write([]byte("some other content"))
+				case "unavailable":
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+			r := &registry{url: server.URL, client: server.Client(), retry: retryPolicy{Retries: 2}}
+			fileName := filepath.Join(t.TempDir(), "a", "a-1.0.0.tgz")
+
+			err := download(r, server.URL+"/a/-/a-1.0.0.tgz", fileName, integrity)
+			if actual := requests.Load(); actual != test.expectedRequests {
+				t.Errorf("expected %d requests, got %d", test.expectedRequests, actual)
+			}
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if _, err = os.Stat(fileName); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected the partial download to be deleted, got %v", err)
+				}
+				if isAlreadyDownloaded(fileName, integrity) {
+					t.Error("expected the tarball not to be downloaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !isAlreadyDownloaded(fileName, integrity) {
+				t.Error("expected the tarball to be downloaded")
+			}
+		})
+	}
+}
+
+func TestIsAlreadyDownloaded(t *testing.T) {
+	tarball := []byte("the content of the tarball")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	tests := []struct {
+		name     string
+		content  []byte
+		expected bool
+	}{
+		{name: "matching", content: tarball, expected: true},
+		{name: "partial", content: tarball[:10]},
+		{name: "missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "a-1.0.0.tgz")
+			if test.content != nil {
+				if err := os.WriteFile(fileName, test.content, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if actual := isAlreadyDownloaded(fileName, integrity); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseLockFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockFile string
+		expected map[string]Package
+	}{
+		{
+			name: "lockfileVersion 1 nested dependencies",
+			lockFile: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "a": {
+      "version": "1.0.0",
+      "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz",
+      "integrity": "sha512-a",
+      "requires": {"b": "^2.0.0"},
+      "dependencies": {
+        "b": {
+          "version": "2.0.0",
+          "resolved": "https://registry.npmjs.org/b/-/b-2.0.0.tgz",
+          "integrity": "sha1-b",
+          "dependencies": {
+            "@scope/c": {"version": "3.0.0", "resolved": "https://registry.npmjs.org/@scope/c/-/c-3.0.0.tgz", "optional": true}
+          }
+        }
+      }
+    },
+    "b": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/b/-/b-1.0.0.tgz", "dev": true},
+    "d": {"version": "github:user/d#0123456789abcdef0123456789abcdef01234567"},
+    "e": {"version": "file:../e"}
+  }
+}`,
+			expected: map[string]Package{
+				"node_modules/a":                                      {Name: "a", Version: "1.0.0", Resolved: "https://registry.npmjs.org/a/-/a-1.0.0.tgz", Integrity: "sha512-a", Dependencies: map[string]string{"b": "^2.0.0"}},
+				"node_modules/a/node_modules/b":                       {Name: "b", Version: "2.0.0", Resolved: "https://registry.npmjs.org/b/-/b-2.0.0.tgz", Integrity: "sha1-b"},
+				"node_modules/a/node_modules/b/node_modules/@scope/c": {Name: "@scope/c", Version: "3.0.0", Resolved: "https://registry.npmjs.org/@scope/c/-/c-3.0.0.tgz", Optional: true},
+				"node_modules/b":                                      {Name: "b", Version: "1.0.0", Resolved: "https://registry.npmjs.org/b/-/b-1.0.0.tgz"},
+				"node_modules/d":                                      {Name: "d", Version: "github:user/d#0123456789abcdef0123456789abcdef01234567", Resolved: "github:user/d#0123456789abcdef0123456789abcdef01234567"},
+				"node_modules/e":                                      {Name: "e", Version: "file:../e", Resolved: "file:../e"},
+			},
+		},
+		{
+			// The dependencies tree is only for backwards compatibility with npm 6.
+			name: "lockfileVersion 2 uses packages",
+			lockFile: `{
+  "lockfileVersion": 2,
+  "packages": {
+    "": {"dependencies": {"alias": "npm:a@^1.0.0"}},
+    "node_modules/alias": {"name": "a", "version": "1.0.0", "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz"}
+  },
+  "dependencies": {
+    "alias": {"version": "npm:a@1.0.0", "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz"},
+    "ignored": {"version": "1.0.0"}
+  }
+}`,
+			expected: map[string]Package{
+				"":                   {Dependencies: map[string]string{"alias": "npm:a@^1.0.0"}},
+				"node_modules/alias": {Name: "a", Version: "1.0.0", Resolved: "https://registry.npmjs.org/a/-/a-1.0.0.tgz"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "package-lock.json")
+			if err := os.WriteFile(fileName, []byte(test.lockFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := parseLockFile(fileName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual.Packages, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, actual.Packages)
+			}
+		})
+	}
+}
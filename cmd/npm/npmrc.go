@@ -0,0 +1,211 @@
+package npm
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// npmrc is the registry configuration and credentials read from .npmrc files.
+type npmrc struct {
+	// Registry is the default registry URL, if set.
+	Registry string
+	// Scopes maps a scope, e.g. @example, to its registry URL.
+	Scopes map[string]string
+	// Credentials are keyed by the "nerf dart" of the registry URL, i.e. the URL without the
+	// scheme, e.g. //npm.pkg.github.com/
+	Credentials map[string]*npmrcCredentials
+}
+
+type npmrcCredentials struct {
+	AuthToken string
+	// Auth is base64 encoded username:password.
+	Auth     string
+	Username string
+	// Password is base64 encoded.
+	Password string
+}
+
+// npmrcFileNames returns the .npmrc files to read, highest precedence first: the explicit file,
+// the project files next to the lock files, and the user file.
+func npmrcFileNames(explicit string, lockFileNames ...string) (fileNames []string) {
+	if explicit != "" {
+		fileNames = append(fileNames, explicit)
+	}
+	for _, lockFileName := range lockFileNames {
+		fileName := filepath.Join(filepath.Dir(lockFileName), ".npmrc")
+		if !contains(fileNames, fileName) {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	if userConfig := os.Getenv("NPM_CONFIG_USERCONFIG"); userConfig != "" {
+		fileNames = append(fileNames, userConfig)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		fileNames = append(fileNames, filepath.Join(home, ".npmrc"))
+	}
+	return fileNames
+}
+
+// loadNpmrc reads the .npmrc files, highest precedence first. Missing files are ignored, unless
+// the file is required.
+func loadNpmrc(required string, fileNames ...string) (rc *npmrc, err error) {
+	rc = &npmrc{
+		Scopes:      make(map[string]string),
+		Credentials: make(map[string]*npmrcCredentials),
+	}
+	// Read the lowest precedence first, so that higher precedence values overwrite them.
+	for i := len(fileNames) - 1; i >= 0; i-- {
+		f, err := os.Open(fileNames[i])
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && fileNames[i] != required {
+				continue
+			}
+			return nil, fmt.Errorf("failed to open npmrc: %w", err)
+		}
+		err = rc.parse(f, fileNames[i])
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (rc *npmrc) parse(f *os.File, fileName string) error {
+	scanner := bufio.NewScanner(f)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key=value", fileName, lineNumber)
+		}
+		key, value = strings.TrimSpace(key), expandNpmrcValue(strings.TrimSpace(value))
+		switch {
+		case key == "registry":
+			rc.Registry = strings.TrimSuffix(value, "/")
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry"):
+			rc.Scopes[strings.TrimSuffix(key, ":registry")] = strings.TrimSuffix(value, "/")
+		case strings.HasPrefix(key, "//"):
+			nerfDart, field, ok := strings.Cut(key, ":")
+			if !ok {
+				continue
+			}
+			rc.setCredential(nerfDart, field, value)
+		case key == "_authToken" || key == "_auth" || key == "username" || key == "_password":
+			// Legacy credentials that apply to the default registry.
+			rc.setCredential("", key, value)
+		}
+	}
+	return scanner.Err()
+}
+
+func (rc *npmrc) setCredential(nerfDart, field, value string) {
+	if nerfDart != "" && !strings.HasSuffix(nerfDart, "/") {
+		nerfDart += "/"
+	}
+	c, ok := rc.Credentials[nerfDart]
+	if !ok {
+		c = new(npmrcCredentials)
+		rc.Credentials[nerfDart] = c
+	}
+	switch field {
+	case "_authToken":
+		c.AuthToken = value
+	case "_auth":
+		c.Auth = value
+	case "username":
+		c.Username = value
+	case "_password":
+		c.Password = value
+	}
+}
+
+// expandNpmrcValue removes quotes, and replaces ${NAME} with environment variables.
+func expandNpmrcValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+	}
+	return os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		// ${NAME?} is an optional variable.
+		return os.Getenv(strings.TrimSuffix(name, "?"))
+	})
+}
+
+// registryFor returns the registry URL for the package, taking into account scoped registries.
+func (rc *npmrc) registryFor(name string) (registryURL string, ok bool) {
+	if scope, _, hasScope := strings.Cut(name, "/"); hasScope && strings.HasPrefix(scope, "@") {
+		if registryURL, ok = rc.Scopes[scope]; ok {
+			return registryURL, true
+		}
+	}
+	return "", false
+}
+
+// credentialsFor returns the credentials for a URL, matching the longest registry path that
+// contains the URL, e.g. //npm.example.com/repository/npm/ matches
+// https://npm.example.com/repository/npm/@scope/name/-/name-1.0.0.tgz
+func (rc *npmrc) credentialsFor(u *url.URL) (c *npmrcCredentials, ok bool) {
+	dir := path.Dir(u.Path)
+	if strings.HasSuffix(u.Path, "/") {
+		dir = strings.TrimSuffix(u.Path, "/")
+	}
+	for {
+		nerfDart := "//" + u.Host + strings.TrimSuffix(dir, "/") + "/"
+		if c, ok = rc.Credentials[nerfDart]; ok {
+			return c, true
+		}
+		if dir == "/" || dir == "." || dir == "" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	// Legacy credentials only apply to the default registry.
+	if c, ok = rc.Credentials[""]; ok {
+		registryURL := rc.Registry
+		if registryURL == "" {
+			registryURL = defaultRegistryURL
+		}
+		if r, err := url.Parse(registryURL); err == nil && r.Host == u.Host {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// authorize adds the credentials for the request URL, if there are any.
+func (rc *npmrc) authorize(req *http.Request) {
+	c, ok := rc.credentialsFor(req.URL)
+	if !ok {
+		return
+	}
+	switch {
+	case c.AuthToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	case c.Auth != "":
+		req.Header.Set("Authorization", "Basic "+c.Auth)
+	case c.Username != "" && c.Password != "":
+		password, err := base64.StdEncoding.DecodeString(c.Password)
+		if err != nil {
+			return
+		}
+		req.SetBasicAuth(c.Username, string(password))
+	}
+}
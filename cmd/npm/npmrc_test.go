@@ -0,0 +1,171 @@
+package npm
+
+import (
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// writeNpmrcFiles writes each .npmrc to a separate directory, and returns their file names in the
+// same order.
+func writeNpmrcFiles(t *testing.T, files []string) (fileNames []string) {
+	t.Helper()
+	for i, content := range files {
+		fileName := filepath.Join(t.TempDir(), strconv.Itoa(i), ".npmrc")
+		if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		fileNames = append(fileNames, fileName)
+	}
+	return fileNames
+}
+
+func TestNpmrcAuthorize(t *testing.T) {
+	basic := func(s string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name string
+		// files are .npmrc files, highest precedence first.
+		files                 []string
+		env                   map[string]string
+		url                   string
+		expectedAuthorization string
+	}{
+		{
+			name:                  "longest matching nerf dart",
+			files:                 []string{"//npm.example.com/:_authToken=host\n//npm.example.com/repository/npm/:_authToken=path\n"},
+			url:                   "https://npm.example.com/repository/npm/@scope/name/-/name-1.0.0.tgz",
+			expectedAuthorization: "Bearer path",
+		},
+		{
+			name:                  "shorter nerf dart",
+			files:                 []string{"//npm.example.com/:_authToken=host\n//npm.example.com/repository/npm/:_authToken=path\n"},
+			url:                   "https://npm.example.com/repository/other/a",
+			expectedAuthorization: "Bearer host",
+		},
+		{
+			name:                  "nerf dart without a trailing slash",
+			files:                 []string{"//npm.example.com/repository/npm:_authToken=path\n"},
+			url:                   "https://npm.example.com/repository/npm/a",
+			expectedAuthorization: "Bearer path",
+		},
+		{
+			name:  "different host",
+			files: []string{"//npm.example.com/:_authToken=host\n"},
+			url:   "https://registry.npmjs.org/a",
+		},
+		{
+			name:                  "environment variables",
+			files:                 []string{"//npm.example.com/:_authToken=\"${NPM_TOKEN}${MISSING?}\"\n"},
+			env:                   map[string]string{"NPM_TOKEN": "secret"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: "Bearer secret",
+		},
+		{
+			name:                  "_authToken takes precedence over _auth",
+			files:                 []string{"//npm.example.com/:_auth=" + base64.StdEncoding.EncodeToString([]byte("a:b")) + "\n//npm.example.com/:_authToken=token\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: "Bearer token",
+		},
+		{
+			name: "_auth takes precedence over username and _password",
+			files: []string{"//npm.example.com/:_auth=" + base64.StdEncoding.EncodeToString([]byte("auth:password")) + "\n" +
+				"//npm.example.com/:username=user\n//npm.example.com/:_password=" + base64.StdEncoding.EncodeToString([]byte("password")) + "\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: basic("auth:password"),
+		},
+		{
+			name:                  "username and _password",
+			files:                 []string{"//npm.example.com/:username=user\n//npm.example.com/:_password=" + base64.StdEncoding.EncodeToString([]byte("password")) + "\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: basic("user:password"),
+		},
+		{
+			name:                  "higher precedence file",
+			files:                 []string{"//npm.example.com/:_authToken=project\n", "//npm.example.com/:_authToken=user\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: "Bearer project",
+		},
+		{
+			name:                  "lower precedence file",
+			files:                 []string{"//other.example.com/:_authToken=project\n", "//npm.example.com/:_authToken=user\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: "Bearer user",
+		},
+		{
+			name:                  "legacy credentials apply to the default registry",
+			files:                 []string{"registry=https://npm.example.com/\n_authToken=legacy\n"},
+			url:                   "https://npm.example.com/a",
+			expectedAuthorization: "Bearer legacy",
+		},
+		{
+			name:  "legacy credentials don't apply to other registries",
+			files: []string{"_authToken=legacy\n"},
+			url:   "https://npm.example.com/a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			rc, err := loadNpmrc("", writeNpmrcFiles(t, test.files)...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.authorize(req)
+			if actual := req.Header.Get("Authorization"); actual != test.expectedAuthorization {
+				t.Errorf("expected Authorization %q, got %q", test.expectedAuthorization, actual)
+			}
+		})
+	}
+}
+
+func TestNpmrcRegistry(t *testing.T) {
+	fileNames := writeNpmrcFiles(t, []string{
+		"; project\n@scope:registry=https://npm.pkg.github.com/\n",
+		"# user\nregistry=https://npm.example.com/\n@scope:registry=https://ignored.example.com/\n@other:registry=https://other.example.com\n",
+	})
+	rc, err := loadNpmrc("", append(fileNames, filepath.Join(t.TempDir(), "missing", ".npmrc"))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "https://npm.example.com"; rc.Registry != expected {
+		t.Errorf("expected registry %q, got %q", expected, rc.Registry)
+	}
+	tests := []struct {
+		name       string
+		expected   string
+		expectedOk bool
+	}{
+		{name: "@scope/a", expected: "https://npm.pkg.github.com", expectedOk: true},
+		{name: "@other/a", expected: "https://other.example.com", expectedOk: true},
+		{name: "@unknown/a"},
+		{name: "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, ok := rc.registryFor(test.name)
+			if actual != test.expected || ok != test.expectedOk {
+				t.Errorf("expected %q %v, got %q %v", test.expected, test.expectedOk, actual, ok)
+			}
+		})
+	}
+
+	// A file that was given explicitly must exist.
+	missing := filepath.Join(t.TempDir(), ".npmrc")
+	if _, err = loadNpmrc(missing, missing); err == nil {
+		t.Error("expected error for a missing required file, got nil")
+	}
+}
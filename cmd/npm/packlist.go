@@ -0,0 +1,257 @@
+package npm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a line of a .npmignore or .gitignore file, or an entry of the files field of
+// package.json, e.g. lib/**/*.js or !test/
+type ignoreRule struct {
+	// Dir is the directory containing the ignore file, relative to the package, or "".
+	Dir string
+	// Parts of the pattern, split on /. ** matches any number of directories.
+	Parts []string
+	// Anchored patterns match relative to Dir. Otherwise, the pattern only has a single part,
+	// which is matched against the name of files and directories at any depth.
+	Anchored bool
+	DirOnly  bool
+	// Negated rules include files that were excluded by an earlier rule.
+	Negated bool
+}
+
+func parseIgnoreRule(dir, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " \t")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	rule.Dir = dir
+	if negated, ok := strings.CutPrefix(line, "!"); ok {
+		rule.Negated = true
+		line = negated
+	}
+	// Escaped leading characters, e.g. \#file or \!file.
+	line = strings.TrimPrefix(line, "\\")
+	if trimmed, ok := strings.CutSuffix(line, "/"); ok {
+		rule.DirOnly = true
+		line = trimmed
+	}
+	// A slash anywhere but the end anchors the pattern to the directory of the ignore file.
+	rule.Anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return rule, false
+	}
+	rule.Parts = strings.Split(line, "/")
+	return rule, true
+}
+
+// match returns true if the rule matches the file or directory, given as a path relative to the
+// package, split on /.
+func (r ignoreRule) match(parts []string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+	if r.Dir != "" {
+		dir := strings.Split(r.Dir, "/")
+		if len(parts) <= len(dir) || !equalParts(parts[:len(dir)], dir) {
+			return false
+		}
+		parts = parts[len(dir):]
+	}
+	if !r.Anchored {
+		ok, _ := path.Match(r.Parts[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchParts(r.Parts, parts)
+}
+
+func equalParts(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchParts matches a pattern to a path, where each part of the pattern matches one part of
+// the path, except **, which matches any number of parts. A trailing ** matches at least one,
+// i.e. everything inside a directory, but not the directory itself.
+func matchParts(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return len(parts) > 0
+		}
+		for i := 0; i <= len(parts); i++ {
+			if matchParts(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchParts(pattern[1:], parts[1:])
+}
+
+// ignoreRules are evaluated in order, and the last rule that matches a path decides whether
+// it's excluded.
+type ignoreRules []ignoreRule
+
+func (rules ignoreRules) excluded(parts []string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(parts, isDir) {
+			return !rules[i].Negated
+		}
+	}
+	return false
+}
+
+// filesRules converts the files field of package.json into ignore rules, in the same way as
+// npm-packlist. Everything is excluded, except the listed files and directories, and the
+// directories that contain them. Negated entries exclude files again.
+func filesRules(files []any) (rules ignoreRules) {
+	rules = append(rules, ignoreRule{Parts: []string{"*"}})
+	for _, f := range files {
+		entry, ok := f.(string)
+		if !ok {
+			continue
+		}
+		negated := strings.HasPrefix(entry, "!")
+		entry = strings.TrimPrefix(entry, "!")
+		entry = strings.TrimSuffix(strings.TrimPrefix(path.Clean("/"+entry), "/"), "/")
+		if entry == "" || entry == "." {
+			continue
+		}
+		parts := strings.Split(entry, "/")
+		if negated {
+			rules = append(rules,
+				ignoreRule{Parts: parts, Anchored: true},
+				ignoreRule{Parts: append(parts[:len(parts):len(parts)], "**"), Anchored: true},
+			)
+			continue
+		}
+		// Include the directories leading to the entry, so that they're walked. Everything
+		// below a ** may lead to the entry.
+		for i := 1; i < len(parts); i++ {
+			rules = append(rules, ignoreRule{Parts: parts[:i], Anchored: true, DirOnly: true, Negated: true})
+			if parts[i-1] == "**" {
+				break
+			}
+		}
+		rules = append(rules,
+			ignoreRule{Parts: parts, Anchored: true, Negated: true},
+			ignoreRule{Parts: append(parts[:len(parts):len(parts)], "**"), Anchored: true, Negated: true},
+		)
+	}
+	return rules
+}
+
+// readIgnoreFiles reads the .npmignore file of each directory in the package, falling back to
+// .gitignore if there isn't one. If skipRoot is true, the ignore file of the package root isn't
+// read, since the files field of package.json takes precedence over it.
+func readIgnoreFiles(dir string, skipRoot bool) (rules ignoreRules, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != dir && contains(alwaysIgnored, d.Name()) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			if skipRoot {
+				return nil
+			}
+			rel = ""
+		}
+		for _, ignoreFileName := range []string{".npmignore", ".gitignore"} {
+			data, err := os.ReadFile(filepath.Join(p, ignoreFileName))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if rule, ok := parseIgnoreRule(rel, line); ok {
+					rules = append(rules, rule)
+				}
+			}
+			break
+		}
+		return nil
+	})
+	return rules, err
+}
+
+// packFilter returns a function that determines whether a file, relative to dir, is included
+// in the packed tarball, using the same rules as npm-packlist.
+//
+// If package.json has a files field, only the listed files and directories are included.
+// Otherwise, everything is included, except files excluded by the .npmignore, or .gitignore, of
+// their directory or any of its parents. .npmignore and .gitignore files in subdirectories apply
+// in both cases. As with git, a file can't be included again if its directory is excluded.
+func packFilter(dir string, manifest map[string]any) (include func(fileName string) bool, err error) {
+	var rules ignoreRules
+	files, hasFiles := manifest["files"].([]any)
+	if hasFiles {
+		rules = filesRules(files)
+	}
+	nested, err := readIgnoreFiles(dir, hasFiles)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, nested...)
+
+	main, _ := manifest["main"].(string)
+	main = strings.TrimPrefix(path.Clean(main), "./")
+	isAlwaysIncluded := func(fileName string) bool {
+		if fileName == main {
+			return true
+		}
+		if strings.Contains(fileName, "/") {
+			return false
+		}
+		for _, pattern := range alwaysIncluded {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(fileName)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return func(fileName string) bool {
+		if isAlwaysIncluded(fileName) {
+			return true
+		}
+		parts := strings.Split(fileName, "/")
+		for i := 1; i < len(parts); i++ {
+			if rules.excluded(parts[:i], true) {
+				return false
+			}
+		}
+		return !rules.excluded(parts, false)
+	}, nil
+}
@@ -0,0 +1,190 @@
+package npm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPackDirectory(t *testing.T) {
+	tests := []struct {
+		name string
+		// files maps file names to their content.
+		files    map[string]string
+		expected []string
+	}{
+		{
+			// Layout of ms@2.1.3.
+			name: "files field with a single file",
+			files: map[string]string{
+				"package.json":  `{"name":"ms","version":"2.1.3","main":"./index","files":["index.js"]}`,
+				"index.js":      "",
+				"license.md":    "",
+				"readme.md":     "",
+				"tests.js":      "",
+				".eslintrc":     "",
+				"lib/index.js":  "",
+				".gitignore":    "node_modules\n",
+				"node_modules/": "",
+			},
+			expected: []string{"index.js", "license.md", "package.json", "readme.md"},
+		},
+		{
+			// Layout of debug@4.3.4.
+			name: "files field with directories",
+			files: map[string]string{
+				"package.json":    `{"name":"debug","version":"4.3.4","main":"./src/index.js","browser":"./src/browser.js","files":["src","LICENSE","README.md"]}`,
+				"src/browser.js":  "",
+				"src/common.js":   "",
+				"src/index.js":    "",
+				"src/node.js":     "",
+				"LICENSE":         "",
+				"README.md":       "",
+				"test.js":         "",
+				"test.node.js":    "",
+				".coveralls.yml":  "",
+				".npmignore":      "src\n",
+				"examples/app.js": "",
+			},
+			expected: []string{"LICENSE", "README.md", "package.json", "src/browser.js", "src/common.js", "src/index.js", "src/node.js"},
+		},
+		{
+			name: "files field with globs and negation",
+			files: map[string]string{
+				"package.json":           `{"name":"a","version":"1.0.0","files":["dist/**/*.js","dist/**/*.d.ts","!dist/**/*.test.js","./bin/"]}`,
+				"dist/index.js":          "",
+				"dist/index.d.ts":        "",
+				"dist/index.js.map":      "",
+				"dist/index.test.js":     "",
+				"dist/lib/util.js":       "",
+				"dist/lib/util.test.js":  "",
+				"dist/lib/deep/x.d.ts":   "",
+				"dist/lib/deep/x.css":    "",
+				"bin/cli":                "",
+				"bin/sub/helper.js":      "",
+				"src/index.ts":           "",
+				"index.js":               "",
+				"LICENCE.txt":            "",
+				"nested/package.json":    "",
+				"nested/README.md":       "",
+				"dist/lib/package.json":  "",
+				"dist/lib/deep/index.js": "",
+			},
+			expected: []string{"LICENCE.txt", "bin/cli", "bin/sub/helper.js", "dist/index.d.ts", "dist/index.js", "dist/lib/deep/index.js", "dist/lib/deep/x.d.ts", "dist/lib/util.js", "package.json"},
+		},
+		{
+			name: "files field with nested .npmignore",
+			files: map[string]string{
+				"package.json":      `{"name":"a","version":"1.0.0","files":["lib"]}`,
+				".npmignore":        "lib\n",
+				"lib/.npmignore":    "*.test.js\nfixtures/\n",
+				"lib/a.js":          "",
+				"lib/a.test.js":     "",
+				"lib/fixtures/x":    "",
+				"lib/sub/b.js":      "",
+				"lib/sub/b.test.js": "",
+			},
+			expected: []string{"lib/a.js", "lib/sub/b.js", "package.json"},
+		},
+		{
+			name: ".npmignore with directories, globs and negation",
+			files: map[string]string{
+				"package.json":      `{"name":"a","version":"1.0.0"}`,
+				".npmignore":        "# Development files\ntest/\n*.log\n!important.log\n/coverage\n**/fixtures/**\ndocs\n!docs/keep.md\n",
+				"index.js":          "",
+				"debug.log":         "",
+				"important.log":     "",
+				"test/index.js":     "",
+				"lib/test/index.js": "",
+				"coverage/lcov":     "",
+				"lib/coverage/x.js": "",
+				"lib/fixtures/a/b":  "",
+				"docs/keep.md":      "",
+				".git/HEAD":         "",
+				".npmrc":            "",
+				"package-lock.json": "",
+			},
+			expected: []string{"important.log", "index.js", "lib/coverage/x.js", "package.json"},
+		},
+		{
+			name: ".gitignore is used if there isn't an .npmignore",
+			files: map[string]string{
+				"package.json":      `{"name":"a","version":"1.0.0","main":"dist/index.js"}`,
+				".gitignore":        "dist\n*.tgz\n",
+				"dist/index.js":     "",
+				"dist/other.js":     "",
+				"a-1.0.0.tgz":       "",
+				"src/index.js":      "",
+				"src/.gitignore":    "generated.js\n",
+				"src/generated.js":  "",
+				"tools/.npmignore":  "*.sh\n",
+				"tools/.gitignore":  "*.js\n",
+				"tools/build.sh":    "",
+				"tools/generate.js": "",
+			},
+			expected: []string{"dist/index.js", "package.json", "src/index.js", "tools/generate.js"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range test.files {
+				fileName := filepath.Join(dir, filepath.FromSlash(name))
+				if strings.HasSuffix(name, "/") {
+					if err := os.MkdirAll(fileName, 0o755); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(fileName), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			target := filepath.Join(t.TempDir(), "package.tgz")
+			if err := packDirectory(dir, target, "1.0.0-file"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := readTarballFileNames(t, target)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+// readTarballFileNames returns the sorted file names in an npm tarball, without the package/
+// prefix.
+func readTarballFileNames(t *testing.T, fileName string) (names []string) {
+	t.Helper()
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, strings.TrimPrefix(hdr.Name, "package/"))
+	}
+	sort.Strings(names)
+	return names
+}
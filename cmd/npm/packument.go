@@ -0,0 +1,186 @@
+package npm
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"log/slog"
+)
+
+// exportedPackage is a tarball that has been downloaded into the output directory.
+type exportedPackage struct {
+	Name     string
+	Version  string
+	FileName string
+	// Source is the lock file resolved value of git and local dependencies that were packed,
+	// rather than downloaded from a registry.
+	Source string
+}
+
+// writePackuments writes a packument for each exported package to <dir>/<name>/index.json,
+// restricted to the versions in the output directory, including those exported by earlier runs,
+// so that the output directory can be served by a plain web server as an npm registry.
+//
+// The dist.tarball of each version is rewritten to the path of the tarball relative to dir,
+// i.e. relative to the root of the registry. npm requests packuments from /<name> and
+// /@scope%2fname, so relative URLs resolve against the root.
+func writePackuments(dir string, r *registry, exported []exportedPackage, log *slog.Logger) (err error) {
+	byName := make(map[string][]exportedPackage)
+	for _, pkg := range exported {
+		// Packages without a name or version, e.g. from a URL, can't be published in a packument.
+		if pkg.Name == "" || pkg.Version == "" {
+			continue
+		}
+		byName[pkg.Name] = append(byName[pkg.Name], pkg)
+	}
+	for name, pkgs := range byName {
+		previous, err := findExportedVersions(dir, name)
+		if err != nil {
+			return fmt.Errorf("failed to find exported versions of %q: %w", name, err)
+		}
+		pkgs = mergeExportedVersions(pkgs, previous)
+		if err = writePackument(dir, r, name, pkgs, log); err != nil {
+			return fmt.Errorf("failed to write packument for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// findExportedVersions returns the tarballs of a package in the output directory, i.e.
+// <dir>/<name>/<name>-<version>.tgz, including packed git and local dependencies, whose versions
+// don't collide with registry versions. Tarballs whose name doesn't match their package.json,
+// e.g. from earlier versions of impex, are skipped.
+func findExportedVersions(dir, name string) (pkgs []exportedPackage, err error) {
+	entries, err := os.ReadDir(filepath.Join(dir, filepath.FromSlash(path.Clean(name))))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tgz") {
+			continue
+		}
+		fileName := filepath.Join(dir, filepath.FromSlash(path.Clean(name)), entry.Name())
+		pkg, err := readExportedPackage(fileName, "")
+		if err != nil {
+			return nil, err
+		}
+		if pkg.Name != name || entry.Name() != path.Base(name)+"-"+pkg.Version+".tgz" {
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// mergeExportedVersions adds the previous versions that weren't exported by this run.
+func mergeExportedVersions(exported, previous []exportedPackage) []exportedPackage {
+	seen := make(map[string]bool, len(exported))
+	for _, pkg := range exported {
+		seen[pkg.Version] = true
+	}
+	for _, pkg := range previous {
+		if !seen[pkg.Version] {
+			seen[pkg.Version] = true
+			exported = append(exported, pkg)
+		}
+	}
+	return exported
+}
+
+func writePackument(dir string, r *registry, name string, pkgs []exportedPackage, log *slog.Logger) (err error) {
+	// Fetch the packument, it's OK if it doesn't exist, or can't be fetched, e.g. because the
+	// registry requires credentials, since the versions can be synthesized from the tarballs.
+	upstream, ok, err := r.getPackument(name)
+	if err != nil {
+		log.Warn("Failed to fetch packument, using the package.json of each tarball", slog.String("name", name), slog.Any("error", err))
+	}
+	if err != nil || !ok {
+		upstream = &packument{Name: name}
+	}
+
+	versions := make(map[string]map[string]any)
+	var versionNames []string
+	for _, pkg := range pkgs {
+		data, err := os.ReadFile(pkg.FileName)
+		if err != nil {
+			return err
+		}
+		var manifest map[string]any
+		if raw, ok := upstream.Versions[pkg.Version]; ok {
+			err = json.Unmarshal(raw, &manifest)
+		} else {
+			manifest, err = readPackageJSON(bytes.NewReader(data))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", pkg.FileName, err)
+		}
+
+		// Set the dist to match the exported tarball.
+		integrity, err := hashReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		tarball, err := filepath.Rel(dir, pkg.FileName)
+		if err != nil {
+			return err
+		}
+		shasum := sha1.Sum(data)
+		dist, _ := manifest["dist"].(map[string]any)
+		if dist == nil {
+			dist = make(map[string]any)
+		}
+		dist["integrity"] = integrity
+		dist["shasum"] = hex.EncodeToString(shasum[:])
+		dist["tarball"] = filepath.ToSlash(tarball)
+		// Signatures are for the upstream tarball URL.
+		delete(dist, "signatures")
+		manifest["dist"] = dist
+		manifest["name"] = name
+		manifest["version"] = pkg.Version
+
+		versions[pkg.Version] = manifest
+		versionNames = append(versionNames, pkg.Version)
+	}
+	sort.Strings(versionNames)
+
+	// Only keep tags that point at exported versions, and make sure there's a latest tag.
+	distTags := make(map[string]string)
+	for tag, version := range upstream.DistTags {
+		if _, ok := versions[version]; ok {
+			distTags[tag] = version
+		}
+	}
+	if _, ok := distTags["latest"]; !ok {
+		if latest, ok := latestVersion(versionNames); ok {
+			distTags["latest"] = latest
+		} else {
+			distTags["latest"] = versionNames[len(versionNames)-1]
+		}
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"name":      name,
+		"dist-tags": distTags,
+		"versions":  versions,
+	})
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, filepath.FromSlash(path.Clean(name)), "index.json")
+	if err = os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, 0660)
+}
@@ -0,0 +1,120 @@
+package npm
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"log/slog"
+)
+
+func TestWritePackuments(t *testing.T) {
+	tests := []struct {
+		name string
+		// authorization is the Authorization header required by the registry.
+		authorization string
+		upstream      *packument
+		// previous are tarballs exported by an earlier run.
+		previous         []string
+		exported         []string
+		expectedVersions []string
+		expectedLatest   string
+	}{
+		{
+			name:             "versions from earlier runs are kept",
+			previous:         []string{"1.0.0", "2.0.0"},
+			exported:         []string{"1.1.0"},
+			expectedVersions: []string{"1.0.0", "1.1.0", "2.0.0", "9.0.0-file"},
+			expectedLatest:   "2.0.0",
+		},
+		{
+			name: "upstream latest tag is kept if it was exported",
+			upstream: &packument{
+				Name:     "a",
+				DistTags: map[string]string{"latest": "1.0.0", "next": "3.0.0"},
+				Versions: map[string]json.RawMessage{"1.0.0": json.RawMessage(`{"description":"upstream"}`)},
+			},
+			previous:         []string{"2.0.0"},
+			exported:         []string{"1.0.0"},
+			expectedVersions: []string{"1.0.0", "2.0.0", "9.0.0-file"},
+			expectedLatest:   "1.0.0",
+		},
+		{
+			name:             "packument that can't be fetched is generated from the tarballs",
+			authorization:    "Bearer secret",
+			exported:         []string{"1.0.0", "1.2.0"},
+			expectedVersions: []string{"1.0.0", "1.2.0", "9.0.0-file"},
+			expectedLatest:   "1.2.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := &fakeRegistry{
+				authorization: test.authorization,
+				packuments:    make(map[string]*packument),
+			}
+			if test.upstream != nil {
+				upstream.packuments["a"] = test.upstream
+			}
+			server := httptest.NewServer(upstream)
+			defer server.Close()
+			r := &registry{url: server.URL, client: http.DefaultClient}
+
+			dir := t.TempDir()
+			for _, version := range test.previous {
+				writeTarball(t, dir, "a", version)
+			}
+			var exported []exportedPackage
+			for _, version := range test.exported {
+				exported = append(exported, exportedPackage{Name: "a", Version: version, FileName: writeTarball(t, dir, "a", version)})
+			}
+			// Packed dependencies are prereleases, so they're not the latest version.
+			writeTarball(t, dir, "a", "9.0.0-file")
+			// Earlier versions of impex packed dependencies without changing their version.
+			packed := writeTarball(t, dir, "a", "9.0.0")
+			if err := os.Rename(packed, filepath.Join(dir, "a", "a-git-0123456.tgz")); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := writePackuments(dir, r, exported, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "a", "index.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual struct {
+				DistTags map[string]string `json:"dist-tags"`
+				Versions map[string]struct {
+					Dist struct {
+						Tarball string `json:"tarball"`
+					} `json:"dist"`
+				} `json:"versions"`
+			}
+			if err = json.Unmarshal(data, &actual); err != nil {
+				t.Fatal(err)
+			}
+			var versions []string
+			for version, manifest := range actual.Versions {
+				versions = append(versions, version)
+				if expected := "a/a-" + version + ".tgz"; manifest.Dist.Tarball != expected {
+					t.Errorf("expected tarball %q, got %q", expected, manifest.Dist.Tarball)
+				}
+			}
+			sort.Strings(versions)
+			if !reflect.DeepEqual(versions, test.expectedVersions) {
+				t.Errorf("expected versions %v, got %v", test.expectedVersions, versions)
+			}
+			if actual.DistTags["latest"] != test.expectedLatest {
+				t.Errorf("expected latest %q, got %q", test.expectedLatest, actual.DistTags["latest"])
+			}
+		})
+	}
+}
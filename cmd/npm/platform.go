@@ -0,0 +1,72 @@
+package npm
+
+import (
+	"strings"
+)
+
+// Platform selects the operating systems, CPU architectures and C libraries to export optional
+// packages for, using the same values as the os, cpu and libc fields of package.json, e.g.
+// linux, darwin, win32, x64, arm64, glibc and musl. Empty lists match everything.
+type Platform struct {
+	OS   []string
+	CPU  []string
+	Libc []string
+}
+
+// includes returns true if the package should be exported for the platform. Required packages
+// are always exported, and optional packages, including devOptional packages, are exported if
+// any of the target operating systems, CPUs and C libraries are supported by the package.
+func (p Platform) includes(pkg Package) bool {
+	if !pkg.Optional && !pkg.DevOptional {
+		return true
+	}
+	if !matchesAny(pkg.Os, p.OS) || !matchesAny(pkg.Cpu, p.CPU) {
+		return false
+	}
+	// libc is only checked by npm on Linux.
+	if len(pkg.Libc) > 0 && (len(p.OS) == 0 || contains(p.OS, "linux")) {
+		return matchesAny(pkg.Libc, p.Libc)
+	}
+	return true
+}
+
+// matchesAny returns true if any of the targets is allowed by the package's list, e.g.
+// ["darwin", "linux"] or ["!win32"]. An empty list of targets, or allowed values, matches
+// everything.
+func matchesAny(allowed, targets []string) bool {
+	if len(allowed) == 0 || len(targets) == 0 {
+		return true
+	}
+	for _, target := range targets {
+		if isAllowed(allowed, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAllowed(allowed []string, target string) bool {
+	var hasPositive, matchesPositive bool
+	for _, value := range allowed {
+		if negated, ok := strings.CutPrefix(value, "!"); ok {
+			if negated == target {
+				return false
+			}
+			continue
+		}
+		hasPositive = true
+		if value == target || value == "any" {
+			matchesPositive = true
+		}
+	}
+	return !hasPositive || matchesPositive
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package npm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformIncludes(t *testing.T) {
+	linuxX64 := Platform{OS: []string{"linux"}, CPU: []string{"x64"}, Libc: []string{"glibc"}}
+	tests := []struct {
+		name     string
+		platform Platform
+		pkg      Package
+		expected bool
+	}{
+		{
+			name:     "required packages are always included",
+			platform: linuxX64,
+			pkg:      Package{Os: []string{"darwin"}},
+			expected: true,
+		},
+		{
+			name:     "matching os and cpu",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"darwin", "linux"}, Cpu: []string{"x64"}},
+			expected: true,
+		},
+		{
+			name:     "other os",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"darwin"}},
+		},
+		{
+			name:     "other cpu",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"linux"}, Cpu: []string{"arm64"}},
+		},
+		{
+			name:     "devOptional packages are optional",
+			platform: linuxX64,
+			pkg:      Package{DevOptional: true, Os: []string{"win32"}},
+		},
+		{
+			name:     "negated os",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"!win32"}},
+			expected: true,
+		},
+		{
+			name:     "negated target os",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"!linux"}},
+		},
+		{
+			name:     "negated cpu with a positive value",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Cpu: []string{"!arm64", "x64"}},
+			expected: true,
+		},
+		{
+			name:     "any target matches",
+			platform: Platform{OS: []string{"darwin", "linux"}, CPU: []string{"arm64"}},
+			pkg:      Package{Optional: true, Os: []string{"!darwin"}, Cpu: []string{"arm64"}},
+			expected: true,
+		},
+		{
+			name:     "matching libc",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"linux"}, Libc: []string{"glibc"}},
+			expected: true,
+		},
+		{
+			name:     "other libc",
+			platform: linuxX64,
+			pkg:      Package{Optional: true, Os: []string{"linux"}, Libc: []string{"musl"}},
+		},
+		{
+			name:     "libc is only checked on linux",
+			platform: Platform{OS: []string{"darwin"}, Libc: []string{"glibc"}},
+			pkg:      Package{Optional: true, Libc: []string{"musl"}},
+			expected: true,
+		},
+		{
+			name:     "empty platform includes everything",
+			pkg:      Package{Optional: true, Os: []string{"aix"}, Cpu: []string{"ppc64"}, Libc: []string{"musl"}},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.platform.includes(test.pkg); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseLockFileDevOptional(t *testing.T) {
+	lockFile := `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"devDependencies": {"vite": "^5.0.0"}},
+    "node_modules/vite": {"version": "5.0.0", "dev": true},
+    "node_modules/fsevents": {"version": "2.3.3", "devOptional": true, "os": ["darwin"]}
+  }
+}`
+	fileName := filepath.Join(t.TempDir(), "package-lock.json")
+	if err := os.WriteFile(fileName, []byte(lockFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := parseLockFile(fileName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pkg := actual.Packages["node_modules/fsevents"]
+	if !pkg.DevOptional {
+		t.Errorf("expected fsevents to be devOptional, got %+v", pkg)
+	}
+	if (Platform{OS: []string{"linux"}}).includes(pkg) {
+		t.Error("expected fsevents to be excluded on linux")
+	}
+}
@@ -0,0 +1,134 @@
+package npm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pnpmLockFile is the subset of pnpm-lock.yaml required to download packages, e.g.
+//
+//	lockfileVersion: '9.0'
+//	packages:
+//	  '@babel/code-frame@7.22.5':
+//	    resolution: {integrity: sha512-...}
+type pnpmLockFile struct {
+	LockfileVersion string                 `yaml:"lockfileVersion"`
+	Packages        map[string]pnpmPackage `yaml:"packages"`
+	// Snapshots are used by lockfileVersion 9 to record the dependencies of each package, and
+	// whether it's optional.
+	Snapshots map[string]pnpmSnapshot `yaml:"snapshots"`
+}
+
+type pnpmSnapshot struct {
+	Optional bool `yaml:"optional"`
+}
+
+type pnpmPackage struct {
+	// Name and Version are only present when they can't be derived from the key, e.g. tarball dependencies.
+	Name       string         `yaml:"name"`
+	Version    string         `yaml:"version"`
+	Resolution pnpmResolution `yaml:"resolution"`
+	Optional   bool           `yaml:"optional"`
+	Os         []string       `yaml:"os"`
+	Cpu        []string       `yaml:"cpu"`
+	Libc       []string       `yaml:"libc"`
+}
+
+type pnpmResolution struct {
+	Integrity string `yaml:"integrity"`
+	Tarball   string `yaml:"tarball"`
+	// Type is set for git and directory dependencies, which aren't downloaded from a registry.
+	Type string `yaml:"type"`
+	// Repo and Commit are set for git dependencies.
+	Repo   string `yaml:"repo"`
+	Commit string `yaml:"commit"`
+	// Directory is set for directory dependencies, relative to the lock file.
+	Directory string `yaml:"directory"`
+}
+
+func parsePnpmLockFile(fileName string, r *registry) (lockFile NPMLock, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	var pnpmLock pnpmLockFile
+	if err = yaml.Unmarshal(data, &pnpmLock); err != nil {
+		return lockFile, fmt.Errorf("failed to parse pnpm lock file: %w", err)
+	}
+	// A package is optional if every snapshot of it, i.e. with different peer dependencies, is optional.
+	optional := make(map[string]bool)
+	for key, snapshot := range pnpmLock.Snapshots {
+		key, _, _ = strings.Cut(key, "(")
+		previous, ok := optional[key]
+		optional[key] = snapshot.Optional && (previous || !ok)
+	}
+	lockFile.Packages = make(map[string]Package)
+	for key, pkg := range pnpmLock.Packages {
+		if o, ok := optional[key]; ok {
+			pkg.Optional = o
+		}
+		name, version := parsePnpmPackageKey(pnpmLock.LockfileVersion, key)
+		if pkg.Name != "" {
+			name = pkg.Name
+		}
+		if pkg.Version != "" {
+			version = pkg.Version
+		}
+		if name == "" || version == "" {
+			return lockFile, fmt.Errorf("%s: failed to determine package name and version", key)
+		}
+		// Registry packages don't include the tarball URL.
+		var resolved string
+		switch pkg.Resolution.Type {
+		case "git":
+			resolved = pkg.Resolution.Repo + "#" + pkg.Resolution.Commit
+			if !isGitDependency(resolved) {
+				resolved = "git+" + resolved
+			}
+		case "directory":
+			resolved = "file:" + pkg.Resolution.Directory
+		case "":
+			resolved = pkg.Resolution.Tarball
+			if resolved == "" {
+				resolved = r.tarballURL(name, version)
+			}
+		default:
+			continue
+		}
+		lockFile.Packages[key] = Package{
+			Name:      name,
+			Version:   version,
+			Resolved:  resolved,
+			Integrity: pkg.Resolution.Integrity,
+			Optional:  pkg.Optional,
+			Os:        pkg.Os,
+			Cpu:       pkg.Cpu,
+			Libc:      pkg.Libc,
+		}
+	}
+	return lockFile, nil
+}
+
+// parsePnpmPackageKey returns the name and version from a packages key. The format depends on
+// the lockfileVersion:
+//
+//	5.x: /@scope/name/1.0.0_peer@2.0.0
+//	6.x: /@scope/name@1.0.0(peer@2.0.0)
+//	9.x: @scope/name@1.0.0(peer@2.0.0)
+func parsePnpmPackageKey(lockfileVersion, key string) (name, version string) {
+	key = strings.TrimPrefix(key, "/")
+	if strings.HasPrefix(lockfileVersion, "5") {
+		i := strings.LastIndex(key, "/")
+		if i < 0 {
+			return "", ""
+		}
+		name, version = key[:i], key[i+1:]
+		version, _, _ = strings.Cut(version, "_")
+		return name, version
+	}
+	key, _, _ = strings.Cut(key, "(")
+	return splitNameAndRange(key)
+}
@@ -0,0 +1,120 @@
+package npm
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePnpmPackageKey(t *testing.T) {
+	tests := []struct {
+		lockfileVersion string
+		key             string
+		expectedName    string
+		expectedVersion string
+	}{
+		{lockfileVersion: "5.4", key: "/ms/2.1.3", expectedName: "ms", expectedVersion: "2.1.3"},
+		{lockfileVersion: "5.4", key: "/@babel/code-frame/7.22.5", expectedName: "@babel/code-frame", expectedVersion: "7.22.5"},
+		{lockfileVersion: "5.4", key: "/react-dom/18.2.0_react@18.2.0", expectedName: "react-dom", expectedVersion: "18.2.0"},
+		{lockfileVersion: "5.4", key: "ms", expectedName: "", expectedVersion: ""},
+		{lockfileVersion: "6.0", key: "/ms@2.1.3", expectedName: "ms", expectedVersion: "2.1.3"},
+		{lockfileVersion: "6.0", key: "/react-dom@18.2.0(react@18.2.0)", expectedName: "react-dom", expectedVersion: "18.2.0"},
+		{lockfileVersion: "6.0", key: "/@scope/name@1.0.0-beta.1(@scope/peer@2.0.0)", expectedName: "@scope/name", expectedVersion: "1.0.0-beta.1"},
+		{lockfileVersion: "9.0", key: "ms@2.1.3", expectedName: "ms", expectedVersion: "2.1.3"},
+		{lockfileVersion: "9.0", key: "@scope/name@1.0.0", expectedName: "@scope/name", expectedVersion: "1.0.0"},
+		{lockfileVersion: "9.0", key: "@scope/name@1.0.0(peer@2.0.0)(@types/node@20.0.0)", expectedName: "@scope/name", expectedVersion: "1.0.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.lockfileVersion+" "+test.key, func(t *testing.T) {
+			name, version := parsePnpmPackageKey(test.lockfileVersion, test.key)
+			if name != test.expectedName || version != test.expectedVersion {
+				t.Errorf("expected %q %q, got %q %q", test.expectedName, test.expectedVersion, name, version)
+			}
+		})
+	}
+}
+
+func TestParsePnpmLockFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockFile string
+		expected map[string]Package
+	}{
+		{
+			// pnpm 7 writes the lockfileVersion as a number.
+			name: "lockfileVersion 5.4",
+			lockFile: `lockfileVersion: 5.4
+
+packages:
+
+  /@babel/code-frame/7.22.5:
+    resolution: {integrity: sha512-a}
+    dev: false
+
+  /fsevents/2.3.3:
+    resolution: {integrity: sha512-b}
+    engines: {node: ^8.16.0 || ^10.6.0 || >=11.0.0}
+    os: [darwin]
+    requiresBuild: true
+    dev: false
+    optional: true
+`,
+			expected: map[string]Package{
+				"/@babel/code-frame/7.22.5": {Name: "@babel/code-frame", Version: "7.22.5", Resolved: "https://registry.npmjs.org/@babel/code-frame/-/code-frame-7.22.5.tgz", Integrity: "sha512-a"},
+				"/fsevents/2.3.3":           {Name: "fsevents", Version: "2.3.3", Resolved: "https://registry.npmjs.org/fsevents/-/fsevents-2.3.3.tgz", Integrity: "sha512-b", Optional: true, Os: []string{"darwin"}},
+			},
+		},
+		{
+			name: "lockfileVersion 9.0",
+			lockFile: `lockfileVersion: '9.0'
+
+packages:
+
+  '@scope/name@1.0.0':
+    resolution: {integrity: sha512-a}
+
+  peer@2.0.0:
+    resolution: {integrity: sha512-b}
+
+  lib@file:../lib:
+    resolution: {directory: ../lib, type: directory}
+    name: lib
+    version: 3.0.0
+
+snapshots:
+
+  '@scope/name@1.0.0(peer@2.0.0)':
+    optional: true
+
+  '@scope/name@1.0.0':
+    dependencies:
+      peer: 2.0.0
+
+  peer@2.0.0:
+    optional: true
+`,
+			expected: map[string]Package{
+				"@scope/name@1.0.0": {Name: "@scope/name", Version: "1.0.0", Resolved: "https://registry.npmjs.org/@scope/name/-/name-1.0.0.tgz", Integrity: "sha512-a"},
+				"peer@2.0.0":        {Name: "peer", Version: "2.0.0", Resolved: "https://registry.npmjs.org/peer/-/peer-2.0.0.tgz", Integrity: "sha512-b", Optional: true},
+				"lib@file:../lib":   {Name: "lib", Version: "3.0.0", Resolved: "file:../lib"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "pnpm-lock.yaml")
+			if err := os.WriteFile(fileName, []byte(test.lockFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			r := &registry{url: "https://registry.npmjs.org"}
+			actual, err := parsePnpmLockFile(fileName, r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual.Packages, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, actual.Packages)
+			}
+		})
+	}
+}
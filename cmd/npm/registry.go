@@ -0,0 +1,195 @@
+package npm
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+const defaultRegistryURL = "https://registry.npmjs.org"
+
+type registry struct {
+	url      string
+	token    string
+	username string
+	password string
+	client   *http.Client
+	retry    retryPolicy
+	// npmrc provides scoped registries, and credentials when token, username and password aren't set.
+	npmrc *npmrc
+
+	m          sync.Mutex
+	packuments map[string]*packument
+}
+
+// packument is the registry document that describes all of the versions of a package.
+// The versions are kept as raw JSON, so that they can be written out unmodified.
+type packument struct {
+	Name     string                     `json:"name"`
+	DistTags map[string]string          `json:"dist-tags"`
+	Versions map[string]json.RawMessage `json:"versions"`
+}
+
+// version returns the subset of the version document used by impex.
+func (p *packument) version(version string) (v packumentVersion, ok bool, err error) {
+	data, ok := p.Versions[version]
+	if !ok {
+		return v, false, nil
+	}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return v, false, fmt.Errorf("failed to decode version %q of package %q: %w", version, p.Name, err)
+	}
+	return v, true, nil
+}
+
+type packumentVersion struct {
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	PeerDependenciesMeta map[string]struct {
+		Optional bool `json:"optional"`
+	} `json:"peerDependenciesMeta"`
+	Os   []string `json:"os"`
+	Cpu  []string `json:"cpu"`
+	Libc []string `json:"libc"`
+	Dist struct {
+		Tarball   string `json:"tarball"`
+		Integrity string `json:"integrity"`
+		Shasum    string `json:"shasum"`
+	} `json:"dist"`
+}
+
+// urlFor returns the registry URL for a package, which may be different for scoped packages.
+func (r *registry) urlFor(name string) string {
+	if r.npmrc != nil {
+		if registryURL, ok := r.npmrc.registryFor(name); ok {
+			return registryURL
+		}
+	}
+	return r.url
+}
+
+func (r *registry) packageURL(name string) string {
+	return r.urlFor(name) + "/" + escapePackageName(name)
+}
+
+// tarballURL returns the conventional registry URL of a package tarball,
+// e.g. https://registry.npmjs.org/@scope/name/-/name-1.0.0.tgz
+func (r *registry) tarballURL(name, version string) string {
+	return r.urlFor(name) + "/" + name + "/-/" + path.Base(name) + "-" + version + ".tgz"
+}
+
+func (r *registry) authorize(req *http.Request) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+		return
+	}
+	if r.username != "" || r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+		return
+	}
+	if r.npmrc != nil {
+		r.npmrc.authorize(req)
+	}
+}
+
+// getPackument fetches the abbreviated packument for a package. Packuments are cached, since
+// lock files often contain many versions of the same package. If the package doesn't exist,
+// ok is false.
+func (r *registry) getPackument(name string) (p *packument, ok bool, err error) {
+	r.m.Lock()
+	p, ok = r.packuments[name]
+	r.m.Unlock()
+	if ok {
+		return p, true, nil
+	}
+
+	err = r.retry.do(r.packageURL(name), func() error {
+		p, ok, err = r.fetchPackument(name)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.packuments == nil {
+		r.packuments = make(map[string]*packument)
+	}
+	r.packuments[name] = p
+	return p, true, nil
+}
+
+func (r *registry) fetchPackument(name string) (p *packument, ok bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, r.packageURL(name), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8")
+	r.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err = checkStatus(resp, http.StatusOK); err != nil {
+		return nil, false, err
+	}
+	p = new(packument)
+	if err = json.NewDecoder(resp.Body).Decode(p); err != nil {
+		return nil, false, fmt.Errorf("failed to decode packument for %q: %w", name, err)
+	}
+	return p, true, nil
+}
+
+// getIntegrity returns the integrity of a package version from the registry, falling back to
+// the legacy sha1 shasum for old packages that don't have an integrity field.
+func (r *registry) getIntegrity(name, version string) (integrity string, err error) {
+	p, ok, err := r.getPackument(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("package %q not found in registry %q", name, r.urlFor(name))
+	}
+	v, ok, err := p.version(version)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("version %q of package %q not found in registry %q", version, name, r.urlFor(name))
+	}
+	if v.Dist.Integrity != "" {
+		return v.Dist.Integrity, nil
+	}
+	if v.Dist.Shasum != "" {
+		return sha1HexToIntegrity(v.Dist.Shasum)
+	}
+	return "", fmt.Errorf("no integrity found for %s@%s in registry %q", name, version, r.urlFor(name))
+}
+
+// escapePackageName escapes the slash in scoped package names, e.g. @scope/name becomes @scope%2fname.
+func escapePackageName(name string) string {
+	return strings.Replace(name, "/", "%2f", 1)
+}
+
+// sha1HexToIntegrity converts a hex encoded sha1 hash, as used by the legacy shasum field and
+// yarn classic resolved URLs, into an integrity string.
+func sha1HexToIntegrity(shasum string) (integrity string, err error) {
+	b, err := hex.DecodeString(shasum)
+	if err != nil {
+		return "", fmt.Errorf("invalid sha1 hash %q: %w", shasum, err)
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(b), nil
+}
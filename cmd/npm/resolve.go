@@ -0,0 +1,271 @@
+package npm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"log/slog"
+)
+
+// ReadPackageSpecs reads package specs, e.g. typescript@5, from a file with one spec per line.
+// Empty lines and lines starting with # are ignored.
+func ReadPackageSpecs(fileName string) (specs []string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open package list: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, line)
+	}
+	return specs, scanner.Err()
+}
+
+// dependencyRequest is a dependency that needs to be resolved and placed in the tree.
+type dependencyRequest struct {
+	// Parent is the lock file path of the package that depends on this one, or "" for the root.
+	Parent string
+	Name   string
+	Spec   string
+	// Optional is true for optional dependencies, and for every dependency of an optional
+	// dependency, since npm skips them all if the optional dependency can't be installed.
+	Optional bool
+	// Peer is true for peer dependencies, and for every dependency of a peer dependency.
+	Peer bool
+}
+
+// resolvePackages resolves the package specs, and the full tree of their dependencies, peer
+// dependencies and optional dependencies, from the registry. The result is a lockfileVersion 3
+// lock file. Packages are hoisted as high in the node_modules tree as possible, and nested when
+// there's a conflicting version of the same package. Peer dependencies are placed next to the
+// package that requires them, so that they're shared with the packages that also depend on it.
+//
+// As with npm, a package is marked optional or peer only if every path to it from the root goes
+// through an optional or peer dependency.
+func resolvePackages(r *registry, specs []string, log *slog.Logger) (lockFile NPMLock, err error) {
+	root := Package{
+		Name:         "impex-export",
+		Version:      "0.0.0",
+		Dependencies: make(map[string]string),
+	}
+	var queue []dependencyRequest
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		name, rng := splitNameAndRange(spec)
+		if rng == "" {
+			rng = "latest"
+		}
+		root.Dependencies[name] = rng
+		queue = append(queue, dependencyRequest{Name: name, Spec: rng})
+	}
+
+	lockFile = NPMLock{
+		Name:            root.Name,
+		Version:         root.Version,
+		LockfileVersion: 3,
+		Packages:        map[string]Package{"": root},
+	}
+	// The packument version of each placed package, to queue its dependencies again if it turns
+	// out not to be optional, or not to be a peer.
+	placed := make(map[string]packumentVersion)
+	enqueue := func(key string, v packumentVersion, optional, peer bool) {
+		// Sort the dependencies, so that the tree is the same each time.
+		for _, name := range sortedKeys(v.Dependencies) {
+			queue = append(queue, dependencyRequest{Parent: key, Name: name, Spec: v.Dependencies[name], Optional: optional, Peer: peer})
+		}
+		for _, name := range sortedKeys(v.OptionalDependencies) {
+			queue = append(queue, dependencyRequest{Parent: key, Name: name, Spec: v.OptionalDependencies[name], Optional: true, Peer: peer})
+		}
+		for _, name := range sortedKeys(v.PeerDependencies) {
+			if v.PeerDependenciesMeta[name].Optional {
+				continue
+			}
+			queue = append(queue, dependencyRequest{Parent: key, Name: name, Spec: v.PeerDependencies[name], Optional: optional, Peer: true})
+		}
+	}
+	// Resolve breadth first, so that packages are placed as high as possible in the tree.
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		pkg, v, err := resolveDependency(r, req.Name, req.Spec)
+		if err != nil {
+			if req.Optional {
+				log.Warn("Skipping optional dependency", slog.String("name", req.Name), slog.String("spec", req.Spec), slog.Any("error", err))
+				continue
+			}
+			return lockFile, fmt.Errorf("failed to resolve %s@%s required by %q: %w", req.Name, req.Spec, req.Parent, err)
+		}
+		pkg.Optional = req.Optional
+		pkg.Peer = req.Peer
+
+		// Peer dependencies are provided by the package's parent, so they're placed in the
+		// node_modules directory that contains the package, rather than nested inside it.
+		parent := req.Parent
+		if req.Peer && parent != "" {
+			parent = parentPackagePath(parent)
+		}
+		key, exists := placePackage(lockFile.Packages, parent, req.Name, pkg.Version)
+		if exists {
+			existing := lockFile.Packages[key]
+			if existing.Version != pkg.Version && req.Peer {
+				log.Warn("Conflicting peer dependency, keeping the version that's already installed", slog.String("name", req.Name), slog.String("spec", req.Spec), slog.String("parent", req.Parent), slog.String("version", existing.Version))
+			}
+			// A package that was reached through an optional or peer dependency is no longer
+			// optional or a peer if it's also reached without one, and neither are its
+			// dependencies.
+			if (existing.Optional && !req.Optional) || (existing.Peer && !req.Peer) {
+				existing.Optional = existing.Optional && req.Optional
+				existing.Peer = existing.Peer && req.Peer
+				lockFile.Packages[key] = existing
+				enqueue(key, placed[key], existing.Optional, existing.Peer)
+			}
+			continue
+		}
+		// The name is only required in the lock file for aliases.
+		if pkg.Name == req.Name {
+			pkg.Name = ""
+		}
+		lockFile.Packages[key] = pkg
+		placed[key] = v
+		enqueue(key, v, pkg.Optional, pkg.Peer)
+	}
+	return lockFile, nil
+}
+
+func sortedKeys(m map[string]string) (keys []string) {
+	keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveDependency finds the version of the package matching the spec. As with npm, the latest
+// dist-tag is preferred if it satisfies the range. Aliases, e.g. npm:name@^1.0.0, are supported.
+func resolveDependency(r *registry, name, spec string) (pkg Package, v packumentVersion, err error) {
+	if target, ok := strings.CutPrefix(spec, "npm:"); ok {
+		name, spec = splitNameAndRange(target)
+	}
+	if spec == "" {
+		spec = "latest"
+	}
+	p, ok, err := r.getPackument(name)
+	if err != nil {
+		return pkg, v, err
+	}
+	if !ok {
+		return pkg, v, fmt.Errorf("package %q not found in registry %q", name, r.urlFor(name))
+	}
+
+	version, ok := p.DistTags[spec]
+	if !ok {
+		rng, err := parseRange(spec)
+		if err != nil {
+			return pkg, v, fmt.Errorf("unsupported dependency %q, only registry versions, ranges and tags are supported: %w", spec, err)
+		}
+		versions := make([]string, 0, len(p.Versions))
+		for version := range p.Versions {
+			versions = append(versions, version)
+		}
+		latest, hasLatest := p.DistTags["latest"]
+		latestSemver, err := parseSemver(latest)
+		if hasLatest && err == nil && rng.satisfies(latestSemver) {
+			version = latest
+		} else if version, ok = rng.maxSatisfying(versions); !ok {
+			return pkg, v, fmt.Errorf("no version of %q matches %q", name, spec)
+		}
+	}
+
+	v, ok, err = p.version(version)
+	if err != nil {
+		return pkg, v, err
+	}
+	if !ok {
+		return pkg, v, fmt.Errorf("version %q of %q not found", version, name)
+	}
+	integrity := v.Dist.Integrity
+	if integrity == "" && v.Dist.Shasum != "" {
+		if integrity, err = sha1HexToIntegrity(v.Dist.Shasum); err != nil {
+			return pkg, v, err
+		}
+	}
+	dependencies := make(map[string]string)
+	for _, deps := range []map[string]string{v.Dependencies, v.OptionalDependencies} {
+		for depName, depSpec := range deps {
+			dependencies[depName] = depSpec
+		}
+	}
+	pkg = Package{
+		Name:         name,
+		Version:      version,
+		Resolved:     v.Dist.Tarball,
+		Integrity:    integrity,
+		Dependencies: dependencies,
+		Os:           v.Os,
+		Cpu:          v.Cpu,
+		Libc:         v.Libc,
+	}
+	if len(pkg.Dependencies) == 0 {
+		pkg.Dependencies = nil
+	}
+	return pkg, v, nil
+}
+
+// placePackage finds the lock file path for a package required by the parent. If the same
+// version is already visible from the parent, exists is true. Otherwise, it's hoisted to the
+// root node_modules directory, or nested in the parent's node_modules directory if a different
+// version is visible from the parent. Nesting directly under the parent, rather than at an
+// intermediate directory, avoids hiding versions that have already been resolved by other
+// packages.
+func placePackage(packages map[string]Package, parent, name, version string) (key string, exists bool) {
+	dir := parent
+	for {
+		candidate := path.Join(dir, "node_modules", name)
+		if existing, ok := packages[candidate]; ok {
+			if existing.Version == version || dir == parent {
+				// If the parent already has a different version, e.g. because it's both a
+				// dependency and a peer dependency, keep the first.
+				return candidate, true
+			}
+			return path.Join(parent, "node_modules", name), false
+		}
+		if dir == "" {
+			return candidate, false
+		}
+		dir = parentPackagePath(dir)
+	}
+}
+
+// parentPackagePath returns the path of the package containing the node_modules directory of
+// the package, e.g. node_modules/a for node_modules/a/node_modules/b, or "" for the root.
+func parentPackagePath(key string) string {
+	i := strings.LastIndex(key, "/node_modules/")
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+// writeLockFile writes the lock file as JSON.
+func writeLockFile(fileName string, lockFile NPMLock) error {
+	data, err := json.MarshalIndent(lockFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(fileName), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(data, '\n'), 0660)
+}
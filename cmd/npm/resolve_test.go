@@ -0,0 +1,106 @@
+package npm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"log/slog"
+)
+
+func TestResolvePackages(t *testing.T) {
+	// Versions of each package, with the JSON of their dependencies.
+	versions := map[string]map[string]string{
+		"app": {
+			"1.0.0": `"dependencies":{"lib":"^1.0.0"},"optionalDependencies":{"fsevents":"^2.0.0","gone":"^1.0.0"}`,
+		},
+		"lib": {
+			"1.0.0": `"dependencies":{"shared":"^2.0.0"}`,
+		},
+		"shared": {
+			"1.0.0": ``,
+			"2.0.0": `"dependencies":{"bindings":"^1.0.0"},"peerDependencies":{"react":"^18.0.0","react-dom":"^18.0.0"},"peerDependenciesMeta":{"react-dom":{"optional":true}}`,
+		},
+		"fsevents": {
+			"2.0.0": `"dependencies":{"bindings":"^1.0.0","missing":"^1.0.0"}`,
+		},
+		"bindings": {
+			"1.0.0": `"dependencies":{"file-uri":"^1.0.0"}`,
+		},
+		"file-uri": {
+			"1.0.0": ``,
+		},
+		"react": {
+			"17.0.0": ``,
+			"18.0.0": `"dependencies":{"loose-envify":"^1.0.0"}`,
+		},
+		"loose-envify": {
+			"1.0.0": ``,
+		},
+	}
+	upstream := &fakeRegistry{packuments: make(map[string]*packument)}
+	for name, byVersion := range versions {
+		p := &packument{Name: name, DistTags: map[string]string{}, Versions: map[string]json.RawMessage{}}
+		for version, deps := range byVersion {
+			if deps != "" {
+				deps = "," + deps
+			}
+			p.Versions[version] = json.RawMessage(fmt.Sprintf(`{"name":%q,"version":%q,"dist":{"tarball":"https://registry.example.com/%s/-/%s-%s.tgz","integrity":"sha512-%s"}%s}`, name, version, name, name, version, version, deps))
+			if latest, ok := maxVersion([]string{version, p.DistTags["latest"]}); ok {
+				p.DistTags["latest"] = latest
+			}
+		}
+		upstream.packuments[name] = p
+	}
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	r := &registry{url: server.URL, client: http.DefaultClient}
+
+	lockFile, err := resolvePackages(r, []string{"app", "react@17", "shared@1"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type placed struct {
+		Version  string
+		Optional bool
+		Peer     bool
+	}
+	expected := map[string]placed{
+		"node_modules/app":    {Version: "1.0.0"},
+		"node_modules/react":  {Version: "17.0.0"},
+		"node_modules/shared": {Version: "1.0.0"},
+		"node_modules/lib":    {Version: "1.0.0"},
+		// Nested, since the root has shared@1.
+		"node_modules/lib/node_modules/shared": {Version: "2.0.0"},
+		// The peer dependency of shared@2 is placed next to it, rather than inside it, since the
+		// root has react@17.
+		"node_modules/lib/node_modules/react": {Version: "18.0.0", Peer: true},
+		// Dependencies of peer dependencies are peer dependencies too.
+		"node_modules/loose-envify": {Version: "1.0.0", Peer: true},
+		"node_modules/fsevents":     {Version: "2.0.0", Optional: true},
+		// Reached through fsevents first, but shared@2 requires it too, so it isn't optional, and
+		// neither are its dependencies.
+		"node_modules/bindings": {Version: "1.0.0"},
+		"node_modules/file-uri": {Version: "1.0.0"},
+	}
+	actual := make(map[string]placed)
+	for key, pkg := range lockFile.Packages {
+		if key == "" {
+			continue
+		}
+		actual[key] = placed{Version: pkg.Version, Optional: pkg.Optional, Peer: pkg.Peer}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// A dependency that can't be resolved is an error, unless it's optional.
+	if _, err = resolvePackages(r, []string{"missing"}, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("expected error for a missing dependency, got nil")
+	}
+}
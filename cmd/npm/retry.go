@@ -0,0 +1,121 @@
+package npm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"log/slog"
+)
+
+// retryPolicy controls how failed registry requests are retried. The zero value doesn't retry.
+type retryPolicy struct {
+	// Retries is the maximum number of attempts after the first.
+	Retries int
+	// Delay is the wait before the first retry, which is doubled for each subsequent retry, up
+	// to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	Log      *slog.Logger
+}
+
+// retryableError is a transient failure, e.g. a 503 from the registry or a dropped connection.
+type retryableError struct {
+	Err error
+	// RetryAfter is the wait requested by the server in the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.Err
+}
+
+// do calls fn until it succeeds, returns an error that isn't transient, or the retries are used
+// up.
+func (p retryPolicy) do(description string, fn func() error) (err error) {
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.Retries || !isRetryable(err) {
+			return err
+		}
+		wait := p.backoff(attempt, err)
+		if p.Log != nil {
+			p.Log.Warn("Retrying", slog.String("request", description), slog.Int("attempt", attempt+1), slog.String("wait", wait.String()), slog.Any("error", err))
+		}
+		time.Sleep(wait)
+	}
+}
+
+// backoff returns the wait before the retry. The Retry-After header is used if the server sent
+// one, up to MaxDelay, so that a misbehaving server can't stall the export. Otherwise the delay
+// doubles for each attempt, with jitter so that concurrent downloads don't retry at the same time.
+func (p retryPolicy) backoff(attempt int, err error) time.Duration {
+	var re *retryableError
+	if errors.As(err, &re) && re.RetryAfter > 0 {
+		if p.MaxDelay > 0 && re.RetryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return re.RetryAfter
+	}
+	delay := p.Delay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Wait between half and all of the delay.
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isRetryable returns true for errors that may succeed if the request is made again: server
+// errors, rate limiting, timeouts and dropped connections.
+func isRetryable(err error) bool {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// checkStatus returns an error if the response status isn't expected. Server errors and rate
+// limiting are retryable.
+func checkStatus(resp *http.Response, expected int) error {
+	if resp.StatusCode == expected {
+		return nil
+	}
+	err := fmt.Errorf("expected status %s for %q, but got %d", http.StatusText(expected), resp.Request.URL, resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &retryableError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return err
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds, or an
+// HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
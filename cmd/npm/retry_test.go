@@ -0,0 +1,107 @@
+package npm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{Delay: time.Second, MaxDelay: time.Minute}
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "first attempt", attempt: 0, err: errors.New("reset"), min: 500 * time.Millisecond, max: time.Second},
+		{name: "doubles", attempt: 2, err: errors.New("reset"), min: 2 * time.Second, max: 4 * time.Second},
+		{name: "limited to max delay", attempt: 20, err: errors.New("reset"), min: 30 * time.Second, max: time.Minute},
+		{name: "retry after", attempt: 0, err: &retryableError{Err: errors.New("503"), RetryAfter: 10 * time.Second}, min: 10 * time.Second, max: 10 * time.Second},
+		{name: "retry after limited to max delay", attempt: 0, err: &retryableError{Err: errors.New("429"), RetryAfter: time.Hour}, min: time.Minute, max: time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := p.backoff(test.attempt, test.err)
+			if actual < test.min || actual > test.max {
+				t.Errorf("expected a wait between %v and %v, got %v", test.min, test.max, actual)
+			}
+		})
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are returned for each request in turn, and the last is repeated.
+		statuses         []int
+		expectedRequests int32
+		expectedErr      bool
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, expectedRequests: 1},
+		{name: "rate limited", statuses: []int{http.StatusTooManyRequests, http.StatusOK}, expectedRequests: 2},
+		{name: "server errors", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, expectedRequests: 3},
+		{name: "retries used up", statuses: []int{http.StatusServiceUnavailable}, expectedRequests: 3, expectedErr: true},
+		{name: "not found isn't retried", statuses: []int{http.StatusNotFound, http.StatusOK}, expectedRequests: 1, expectedErr: true},
+		{name: "unauthorized isn't retried", statuses: []int{http.StatusUnauthorized, http.StatusOK}, expectedRequests: 1, expectedErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1)) - 1
+				w.WriteHeader(test.statuses[min(n, len(test.statuses)-1)])
+			}))
+			defer server.Close()
+
+			p := retryPolicy{Retries: 2}
+			err := p.do(server.URL, func() error {
+				resp, err := http.Get(server.URL)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				return checkStatus(resp, http.StatusOK)
+			})
+			if actual := requests.Load(); actual != test.expectedRequests {
+				t.Errorf("expected %d requests, got %d", test.expectedRequests, actual)
+			}
+			if test.expectedErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !test.expectedErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "retryable status", err: &retryableError{Err: errors.New("503")}, expected: true},
+		{name: "wrapped retryable status", err: fmt.Errorf("download: %w", &retryableError{Err: errors.New("429")}), expected: true},
+		{name: "timeout", err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, expected: true},
+		{name: "dropped connection", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), expected: true},
+		{name: "other status", err: errors.New("expected status OK, but got 404"), expected: false},
+		{name: "hash mismatch", err: errors.New("expected hash \"sha512-a\", got \"sha512-b\""), expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := isRetryable(test.err); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package npm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+type RewriteArguments struct {
+	// FileName of the package-lock.json to rewrite in place.
+	FileName string
+	// Directory containing the exported tarballs, e.g. package/npm. Only entries with a
+	// tarball in the directory are rewritten.
+	Directory string
+	// RegistryURL is the base URL of the registry the tarballs were imported into,
+	// e.g. https://nexus.internal/repository/npm/
+	RegistryURL string
+	Log         *slog.Logger
+}
+
+// RewriteLock rewrites the resolved URLs of a package-lock.json to point at the registry the
+// exported tarballs were imported into. Only the resolved values are changed, so the integrity
+// and formatting of the file are preserved. Entries that can't be mapped to an exported tarball
+// are left unchanged, and reported.
+func RewriteLock(args RewriteArguments) error {
+	start := time.Now()
+
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	if _, err := os.Stat(args.Directory); err != nil {
+		return fmt.Errorf("failed to open export directory: %w", err)
+	}
+	sources, err := readSources(args.Directory)
+	if err != nil {
+		return err
+	}
+	r := &registry{
+		url: strings.TrimSuffix(args.RegistryURL, "/"),
+	}
+
+	log.Info("Parsing lock file", slog.String("file", args.FileName))
+	data, err := os.ReadFile(args.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to read lock file: %w", err)
+	}
+	values, err := findResolvedValues(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse lock file: %w", err)
+	}
+
+	// Replace from the end of the file, so that the offsets of earlier values don't change.
+	var rewritten int
+	var unmapped []string
+	for i := len(values) - 1; i >= 0; i-- {
+		v := values[i]
+		var to string
+		var ok bool
+		if v.Key == "version" {
+			to, ok, err = rewriteVersion(args.Directory, sources, r, v.Value)
+		} else {
+			to, ok, err = rewriteResolved(args.Directory, sources, r, v.Value)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			unmapped = append(unmapped, v.Value)
+			continue
+		}
+		if to == v.Value {
+			continue
+		}
+		encoded, err := encodeJSONString(to)
+		if err != nil {
+			return err
+		}
+		data = append(data[:v.Start:v.Start], append(encoded, data[v.End:]...)...)
+		rewritten++
+	}
+
+	info, err := os.Stat(args.FileName)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(args.FileName, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	for i := len(unmapped) - 1; i >= 0; i-- {
+		log.Warn("Not exported", slog.String("resolved", unmapped[i]))
+	}
+	log.Info("Complete", slog.Int("total", len(values)), slog.Int("rewritten", rewritten), slog.Int("unmapped", len(unmapped)), slog.String("duration", time.Now().Sub(start).String()))
+	if len(unmapped) > 0 {
+		return fmt.Errorf("%d of %d resolved URLs could not be mapped to an exported tarball", len(unmapped), len(values))
+	}
+	return nil
+}
+
+// rewriteResolved returns the registry URL of the exported tarball for a resolved value.
+// Local paths, i.e. workspace and directory links, don't refer to a tarball, so they're returned
+// unchanged.
+func rewriteResolved(dir string, sources map[string]packageSource, r *registry, resolved string) (to string, ok bool, err error) {
+	// Git and local dependencies are packed during export, and published under their name and
+	// packed version, e.g. 1.0.0-git.<commit>.
+	if isGitDependency(resolved) || strings.HasPrefix(resolved, "file:") {
+		source, ok := sources[resolved]
+		if !ok {
+			return "", false, nil
+		}
+		return r.tarballURL(source.Name, source.Version), true, nil
+	}
+	if !strings.HasPrefix(resolved, "http://") && !strings.HasPrefix(resolved, "https://") {
+		return resolved, true, nil
+	}
+	name, tarball, err := parseTarballURL(resolved)
+	if err != nil || !isValidPackageName(name) {
+		return "", false, nil
+	}
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(path.Join(name, tarball))))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return r.urlFor(name) + "/" + name + "/-/" + tarball, true, nil
+}
+
+// rewriteVersion returns the registry URL of the exported tarball for the version of a
+// lockfileVersion 1 dependency that isn't from a registry. Git, file: and remote tarball
+// dependencies store their source in the version, and may not have a resolved value. Any other
+// source can't be mapped to an exported tarball.
+func rewriteVersion(dir string, sources map[string]packageSource, r *registry, version string) (to string, ok bool, err error) {
+	if !isGitDependency(version) && !strings.HasPrefix(version, "file:") && !strings.HasPrefix(version, "http://") && !strings.HasPrefix(version, "https://") {
+		return "", false, nil
+	}
+	return rewriteResolved(dir, sources, r, version)
+}
+
+// jsonValue is the location of a string value in a JSON document. Start and End include the
+// quotes.
+type jsonValue struct {
+	Start int
+	End   int
+	// Key is "resolved", or "version" for the version of lockfileVersion 1 dependencies.
+	Key   string
+	Value string
+}
+
+// findResolvedValues returns the location of every "resolved" string value in the document, at
+// any depth, in the order they appear. The version of lockfileVersion 1 dependencies is also
+// returned if it isn't a registry version, i.e. a semantic version or an npm: alias, since git,
+// file: and remote tarball dependencies store their source there.
+func findResolvedValues(data []byte) (values []jsonValue, err error) {
+	type container struct {
+		object bool
+		// expectKey is true when the next token in an object is a key.
+		expectKey bool
+		key       string
+		// dependencies is true for maps of package names to ranges, where "resolved" is a
+		// package name.
+		dependencies bool
+		// dependency is true for the objects in a dependencies map, i.e. the nested
+		// dependencies of lockfileVersion 1.
+		dependency bool
+	}
+	var stack []*container
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var top *container
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if top != nil && top.object {
+			if top.expectKey {
+				top.key, _ = tok.(string)
+				top.expectKey = false
+				continue
+			}
+			top.expectKey = true
+			s, ok := tok.(string)
+			isResolved := ok && top.key == "resolved" && !top.dependencies
+			isVersion := ok && top.key == "version" && top.dependency && !isValidSemver(s) && !strings.HasPrefix(s, "npm:")
+			if isResolved || isVersion {
+				// The offset is the end of the key, so skip the colon and whitespace.
+				start := offset + bytes.IndexByte(data[offset:], '"')
+				values = append(values, jsonValue{Start: start, End: int(dec.InputOffset()), Key: top.key, Value: s})
+			}
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			c := &container{object: delim == '{', expectKey: delim == '{'}
+			if top != nil && top.dependencies && c.object {
+				c.dependency = true
+			}
+			if top != nil && top.object {
+				switch top.key {
+				case "requires", "dependencies", "optionalDependencies", "peerDependencies", "devDependencies":
+					c.dependencies = true
+				}
+			}
+			stack = append(stack, c)
+		}
+	}
+}
+
+// encodeJSONString encodes the string without escaping HTML characters, as npm does.
+func encodeJSONString(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,172 @@
+package npm
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"log/slog"
+)
+
+func TestRewriteLock(t *testing.T) {
+	tests := []struct {
+		name        string
+		lockFile    string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "lockfileVersion 3",
+			lockFile: `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"dependencies": {"a": "^1.0.0", "lib": "file:../lib", "resolved": "^1.0.0"}},
+    "node_modules/a": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz", "integrity": "sha512-a"},
+    "node_modules/resolved": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/resolved/-/resolved-1.0.0.tgz"},
+    "node_modules/b": {"version": "2.0.0", "resolved": "git+ssh://git@github.com/user/b.git#0123456789abcdef0123456789abcdef01234567"},
+    "node_modules/lib": {"resolved": "../lib", "link": true}
+  }
+}`,
+			expected: `{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"dependencies": {"a": "^1.0.0", "lib": "file:../lib", "resolved": "^1.0.0"}},
+    "node_modules/a": {"version": "1.0.0", "resolved": "https://nexus.internal/repository/npm/a/-/a-1.0.0.tgz", "integrity": "sha512-a"},
+    "node_modules/resolved": {"version": "1.0.0", "resolved": "https://nexus.internal/repository/npm/resolved/-/resolved-1.0.0.tgz"},
+    "node_modules/b": {"version": "2.0.0", "resolved": "https://nexus.internal/repository/npm/b/-/b-2.0.0-git.0123456789abcdef0123456789abcdef01234567.tgz"},
+    "node_modules/lib": {"resolved": "../lib", "link": true}
+  }
+}`,
+		},
+		{
+			name: "lockfileVersion 1 git, file: and tarball versions",
+			lockFile: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "a": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz", "requires": {"version": "^1.0.0"}},
+    "alias": {"version": "npm:a@1.0.0", "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz"},
+    "b": {"version": "git+ssh://git@github.com/user/b.git#0123456789abcdef0123456789abcdef01234567", "from": "git+ssh://git@github.com/user/b.git"},
+    "c": {
+      "version": "file:../c",
+      "dependencies": {
+        "version": {"version": "https://registry.npmjs.org/version/-/version-1.0.0.tgz", "integrity": "sha512-v"}
+      }
+    }
+  }
+}`,
+			expected: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "a": {"version": "1.0.0", "resolved": "https://nexus.internal/repository/npm/a/-/a-1.0.0.tgz", "requires": {"version": "^1.0.0"}},
+    "alias": {"version": "npm:a@1.0.0", "resolved": "https://nexus.internal/repository/npm/a/-/a-1.0.0.tgz"},
+    "b": {"version": "https://nexus.internal/repository/npm/b/-/b-2.0.0-git.0123456789abcdef0123456789abcdef01234567.tgz", "from": "git+ssh://git@github.com/user/b.git"},
+    "c": {
+      "version": "https://nexus.internal/repository/npm/c/-/c-3.0.0-file.tgz",
+      "dependencies": {
+        "version": {"version": "https://nexus.internal/repository/npm/version/-/version-1.0.0.tgz", "integrity": "sha512-v"}
+      }
+    }
+  }
+}`,
+		},
+		{
+			name: "lock file that has already been rewritten",
+			lockFile: `{
+  "lockfileVersion": 3,
+  "packages": {
+    "node_modules/b": {"version": "2.0.0", "resolved": "https://registry.example.com/b/-/b-2.0.0-git.0123456789abcdef0123456789abcdef01234567.tgz"},
+    "node_modules/c": {"version": "3.0.0", "resolved": "https://registry.example.com/c/-/c-3.0.0-file.tgz"}
+  }
+}`,
+			expected: `{
+  "lockfileVersion": 3,
+  "packages": {
+    "node_modules/b": {"version": "2.0.0", "resolved": "https://nexus.internal/repository/npm/b/-/b-2.0.0-git.0123456789abcdef0123456789abcdef01234567.tgz"},
+    "node_modules/c": {"version": "3.0.0", "resolved": "https://nexus.internal/repository/npm/c/-/c-3.0.0-file.tgz"}
+  }
+}`,
+		},
+		{
+			// The registry version has the same name and version as the packed git dependency,
+			// but wasn't exported.
+			name: "registry version of a packed dependency",
+			lockFile: `{
+  "lockfileVersion": 3,
+  "packages": {
+    "node_modules/b": {"version": "2.0.0", "resolved": "https://registry.npmjs.org/b/-/b-2.0.0.tgz"}
+  }
+}`,
+			expectedErr: true,
+		},
+		{
+			name: "lockfileVersion 1 git dependency that wasn't exported",
+			lockFile: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "d": {"version": "github:user/d#0123456789abcdef0123456789abcdef01234567"}
+  }
+}`,
+			expectedErr: true,
+		},
+		{
+			name: "lockfileVersion 1 unsupported source",
+			lockFile: `{
+  "lockfileVersion": 1,
+  "dependencies": {
+    "e": {"version": "link:../e"}
+  }
+}`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, tarball := range []string{"a@1.0.0", "resolved@1.0.0", "version@1.0.0", "b@2.0.0-git.0123456789abcdef0123456789abcdef01234567", "c@3.0.0-file"} {
+				i := strings.LastIndex(tarball, "@")
+				writeTarball(t, dir, tarball[:i], tarball[i+1:])
+			}
+			sources := map[string]packageSource{
+				"git+ssh://git@github.com/user/b.git#0123456789abcdef0123456789abcdef01234567": {Name: "b", Version: "2.0.0-git.0123456789abcdef0123456789abcdef01234567", Tarball: "b/b-2.0.0-git.0123456789abcdef0123456789abcdef01234567.tgz"},
+				"file:../c": {Name: "c", Version: "3.0.0-file", Tarball: "c/c-3.0.0-file.tgz"},
+			}
+			data, err := json.Marshal(sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.WriteFile(filepath.Join(dir, "sources.json"), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			fileName := filepath.Join(t.TempDir(), "package-lock.json")
+			if err = os.WriteFile(fileName, []byte(test.lockFile), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err = RewriteLock(RewriteArguments{
+				FileName:    fileName,
+				Directory:   dir,
+				RegistryURL: "https://nexus.internal/repository/npm/",
+				Log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual, err := os.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, actual)
+			}
+		})
+	}
+}
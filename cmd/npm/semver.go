@@ -0,0 +1,351 @@
+package npm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, e.g. 1.2.3-beta.1+build.
+type semver struct {
+	Major, Minor, Patch int
+	Prerelease          []string
+}
+
+func parseSemver(s string) (v semver, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, prerelease, hasPrerelease := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		if numbers[i], err = strconv.Atoi(part); err != nil || numbers[i] < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+	if hasPrerelease {
+		v.Prerelease = strings.Split(prerelease, ".")
+	}
+	return v, nil
+}
+
+func isValidSemver(s string) bool {
+	_, err := parseSemver(s)
+	return err == nil
+}
+
+// compare returns -1 if v is lower than o, 0 if they're equal, and 1 if v is greater than o.
+func (v semver) compare(o semver) int {
+	for _, c := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c[0] != c[1] {
+			return compareInt(c[0], c[1])
+		}
+	}
+	// A version without a prerelease has higher precedence.
+	if len(v.Prerelease) == 0 || len(o.Prerelease) == 0 {
+		return compareInt(len(o.Prerelease), len(v.Prerelease))
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		a, b := v.Prerelease[i], o.Prerelease[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			return compareInt(an, bn)
+		case aErr == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones.
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			return strings.Compare(a, b)
+		}
+	}
+	return compareInt(len(v.Prerelease), len(o.Prerelease))
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// maxVersion returns the greatest valid semantic version in the list.
+func maxVersion(versions []string) (max string, ok bool) {
+	var maxSemver semver
+	for _, version := range versions {
+		v, err := parseSemver(version)
+		if err != nil {
+			continue
+		}
+		if !ok || v.compare(maxSemver) > 0 {
+			max, maxSemver, ok = version, v, true
+		}
+	}
+	return max, ok
+}
+
+// latestVersion returns the version for the latest tag, i.e. the greatest version that isn't a
+// prerelease, or the greatest prerelease if there isn't one. Packed git and local dependencies
+// are prereleases, so they don't become the latest version.
+func latestVersion(versions []string) (latest string, ok bool) {
+	var releases []string
+	for _, version := range versions {
+		if v, err := parseSemver(version); err == nil && len(v.Prerelease) == 0 {
+			releases = append(releases, version)
+		}
+	}
+	if latest, ok = maxVersion(releases); ok {
+		return latest, true
+	}
+	return maxVersion(versions)
+}
+
+// versionRange is a parsed npm semver range, e.g. "^1.2.3 || >=2.0.0 <3.0.0". A version
+// satisfies the range if it satisfies every comparator of any of the sets.
+type versionRange [][]comparator
+
+type comparator struct {
+	Op      string
+	Version semver
+}
+
+// parseRange parses npm's semver range syntax, including x-ranges (1.x, 1.2.*), tilde (~1.2.3),
+// caret (^1.2.3), hyphen (1.2.3 - 2.3.4) and primitive (>=1.2.3) comparators.
+func parseRange(s string) (r versionRange, err error) {
+	for _, set := range strings.Split(s, "||") {
+		comparators, err := parseComparatorSet(strings.TrimSpace(set))
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+		r = append(r, comparators)
+	}
+	return r, nil
+}
+
+func parseComparatorSet(set string) (comparators []comparator, err error) {
+	// Hyphen ranges, e.g. 1.2.3 - 2.3.4
+	if from, to, ok := strings.Cut(set, " - "); ok {
+		lower, err := parsePartial(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		upper, err := parsePartial(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, lower.lowerBound(">=")...)
+		return append(comparators, upper.upperBound("<=")...), nil
+	}
+
+	// Join operators to their versions, e.g. ">= 1.2.3" becomes ">=1.2.3".
+	fields := strings.Fields(set)
+	var tokens []string
+	for i := 0; i < len(fields); i++ {
+		token := fields[i]
+		if strings.Trim(token, "<>=~^") == "" && i+1 < len(fields) {
+			token += fields[i+1]
+			i++
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return []comparator{}, nil
+	}
+
+	for _, token := range tokens {
+		op := token[:len(token)-len(strings.TrimLeft(token, "<>=~^"))]
+		p, err := parsePartial(token[len(op):])
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "", "=":
+			comparators = append(comparators, p.lowerBound(">=")...)
+			comparators = append(comparators, p.upperBound("<=")...)
+		case "~", "~>":
+			comparators = append(comparators, p.lowerBound(">=")...)
+			comparators = append(comparators, p.tildeUpperBound()...)
+		case "^":
+			comparators = append(comparators, p.lowerBound(">=")...)
+			comparators = append(comparators, p.caretUpperBound()...)
+		case ">", ">=":
+			comparators = append(comparators, p.lowerBound(op)...)
+		case "<", "<=":
+			comparators = append(comparators, p.upperBound(op)...)
+		default:
+			return nil, fmt.Errorf("invalid operator %q", op)
+		}
+	}
+	return comparators, nil
+}
+
+// partial is a version that may be missing parts, or have wildcards, e.g. 1.x or 1.2
+type partial struct {
+	// Parts is the number of parts that are present, 0 to 3.
+	Parts   int
+	Version semver
+}
+
+func parsePartial(s string) (p partial, err error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "="), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, prerelease, hasPrerelease := strings.Cut(s, "-")
+	if s == "" {
+		return p, nil
+	}
+	numbers := make([]int, 3)
+	for i, part := range strings.Split(s, ".") {
+		if i > 2 {
+			return p, fmt.Errorf("invalid version %q", s)
+		}
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		if numbers[i], err = strconv.Atoi(part); err != nil || numbers[i] < 0 {
+			return p, fmt.Errorf("invalid version %q", s)
+		}
+		p.Parts++
+	}
+	p.Version = semver{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}
+	if hasPrerelease && p.Parts == 3 {
+		p.Version.Prerelease = strings.Split(prerelease, ".")
+	}
+	return p, nil
+}
+
+// next returns the lowest version after all versions matched by the partial, e.g. 1.3.0-0 for 1.2
+func (p partial) next(parts int) semver {
+	v := semver{Major: p.Version.Major, Minor: p.Version.Minor, Patch: p.Version.Patch, Prerelease: []string{"0"}}
+	switch parts {
+	case 1:
+		return semver{Major: v.Major + 1, Prerelease: v.Prerelease}
+	case 2:
+		return semver{Major: v.Major, Minor: v.Minor + 1, Prerelease: v.Prerelease}
+	}
+	return semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Prerelease: v.Prerelease}
+}
+
+func (p partial) lowerBound(op string) []comparator {
+	if p.Parts == 0 {
+		if op == ">" {
+			// Nothing is greater than everything.
+			return []comparator{{Op: "<", Version: semver{Prerelease: []string{"0"}}}}
+		}
+		return nil
+	}
+	if op == ">" && p.Parts < 3 {
+		v := p.next(p.Parts)
+		v.Prerelease = nil
+		return []comparator{{Op: ">=", Version: v}}
+	}
+	return []comparator{{Op: op, Version: p.Version}}
+}
+
+func (p partial) upperBound(op string) []comparator {
+	if p.Parts == 0 {
+		if op == "<" {
+			return []comparator{{Op: "<", Version: semver{Prerelease: []string{"0"}}}}
+		}
+		return nil
+	}
+	if p.Parts < 3 {
+		if op == "<" {
+			return []comparator{{Op: "<", Version: semver{Major: p.Version.Major, Minor: p.Version.Minor, Prerelease: []string{"0"}}}}
+		}
+		return []comparator{{Op: "<", Version: p.next(p.Parts)}}
+	}
+	return []comparator{{Op: op, Version: p.Version}}
+}
+
+func (p partial) tildeUpperBound() []comparator {
+	switch p.Parts {
+	case 0:
+		return nil
+	case 1:
+		return []comparator{{Op: "<", Version: p.next(1)}}
+	}
+	return []comparator{{Op: "<", Version: p.next(2)}}
+}
+
+func (p partial) caretUpperBound() []comparator {
+	switch {
+	case p.Parts == 0:
+		return nil
+	case p.Version.Major != 0 || p.Parts == 1:
+		return []comparator{{Op: "<", Version: p.next(1)}}
+	case p.Version.Minor != 0 || p.Parts == 2:
+		return []comparator{{Op: "<", Version: p.next(2)}}
+	}
+	return []comparator{{Op: "<", Version: p.next(3)}}
+}
+
+func (c comparator) matches(v semver) bool {
+	cmp := v.compare(c.Version)
+	switch c.Op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// satisfies returns true if the version is in the range. As with npm, prerelease versions only
+// satisfy a range if a comparator has a prerelease on the same major, minor and patch version.
+func (r versionRange) satisfies(v semver) bool {
+	for _, set := range r {
+		if setSatisfies(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setSatisfies(set []comparator, v semver) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+	for _, c := range set {
+		cv := c.Version
+		if len(cv.Prerelease) > 0 && cv.Major == v.Major && cv.Minor == v.Minor && cv.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSatisfying returns the greatest version in the list that satisfies the range.
+func (r versionRange) maxSatisfying(versions []string) (max string, ok bool) {
+	var maxSemver semver
+	for _, version := range versions {
+		v, err := parseSemver(version)
+		if err != nil || !r.satisfies(v) {
+			continue
+		}
+		if !ok || v.compare(maxSemver) > 0 {
+			max, maxSemver, ok = version, v, true
+		}
+	}
+	return max, ok
+}
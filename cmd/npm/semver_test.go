@@ -0,0 +1,202 @@
+package npm
+
+import (
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{input: "1.2.3", expected: "1.2.3"},
+		{input: "v1.2.3", expected: "1.2.3"},
+		{input: "1.2.3-beta.1", expected: "1.2.3-beta.1"},
+		{input: "1.2.3+build.5", expected: "1.2.3"},
+		{input: "1.2.3-rc.1+build.5", expected: "1.2.3-rc.1"},
+		{input: "1.2", expectedErr: true},
+		{input: "1.2.3.4", expectedErr: true},
+		{input: "1.2.x", expectedErr: true},
+		{input: "latest", expectedErr: true},
+		{input: "", expectedErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			v, err := parseSemver(test.input)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected, err := parseSemver(test.expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.compare(expected) != 0 {
+				t.Errorf("expected %#v, got %#v", expected, v)
+			}
+		})
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	// Versions in ascending order of precedence, from the semver specification.
+	versions := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i, a := range versions {
+		for j, b := range versions {
+			va, err := parseSemver(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vb, err := parseSemver(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := va.compare(vb); actual != compareInt(i, j) {
+				t.Errorf("expected compare(%s, %s) to be %d, got %d", a, b, compareInt(i, j), actual)
+			}
+		}
+	}
+}
+
+func TestRangeSatisfies(t *testing.T) {
+	tests := []struct {
+		rng         string
+		satisfied   []string
+		unsatisfied []string
+	}{
+		// Any version.
+		{rng: "", satisfied: []string{"0.0.0", "1.2.3", "99.0.0"}, unsatisfied: []string{"1.0.0-beta"}},
+		{rng: "*", satisfied: []string{"0.0.0", "1.2.3"}, unsatisfied: []string{"1.0.0-beta"}},
+		{rng: "x", satisfied: []string{"0.0.0", "1.2.3"}},
+		// Exact versions.
+		{rng: "1.2.3", satisfied: []string{"1.2.3"}, unsatisfied: []string{"1.2.4", "1.2.2", "1.2.3-beta"}},
+		{rng: "=1.2.3", satisfied: []string{"1.2.3"}, unsatisfied: []string{"1.2.4"}},
+		{rng: "v1.2.3", satisfied: []string{"1.2.3"}, unsatisfied: []string{"1.2.4"}},
+		{rng: "1.2.3-beta.1", satisfied: []string{"1.2.3-beta.1"}, unsatisfied: []string{"1.2.3-beta.2", "1.2.3"}},
+		// X-ranges.
+		{rng: "1", satisfied: []string{"1.0.0", "1.9.9"}, unsatisfied: []string{"0.9.9", "2.0.0", "2.0.0-0", "1.5.0-beta"}},
+		{rng: "1.x", satisfied: []string{"1.0.0", "1.9.9"}, unsatisfied: []string{"0.9.9", "2.0.0"}},
+		{rng: "1.2", satisfied: []string{"1.2.0", "1.2.9"}, unsatisfied: []string{"1.1.9", "1.3.0"}},
+		{rng: "1.2.x", satisfied: []string{"1.2.0", "1.2.9"}, unsatisfied: []string{"1.3.0"}},
+		{rng: "1.2.*", satisfied: []string{"1.2.0", "1.2.9"}, unsatisfied: []string{"1.3.0"}},
+		{rng: "1.X", satisfied: []string{"1.0.0"}, unsatisfied: []string{"2.0.0"}},
+		// Tilde ranges.
+		{rng: "~1.2.3", satisfied: []string{"1.2.3", "1.2.9"}, unsatisfied: []string{"1.2.2", "1.3.0"}},
+		{rng: "~1.2", satisfied: []string{"1.2.0", "1.2.9"}, unsatisfied: []string{"1.3.0"}},
+		{rng: "~1", satisfied: []string{"1.0.0", "1.9.9"}, unsatisfied: []string{"2.0.0"}},
+		{rng: "~0.2.3", satisfied: []string{"0.2.3", "0.2.9"}, unsatisfied: []string{"0.3.0"}},
+		{rng: "~>1.2.3", satisfied: []string{"1.2.9"}, unsatisfied: []string{"1.3.0"}},
+		{rng: "~1.2.3-beta.2", satisfied: []string{"1.2.3-beta.2", "1.2.3-beta.4", "1.2.3", "1.2.9"}, unsatisfied: []string{"1.2.3-beta.1", "1.2.4-beta.2", "1.3.0"}},
+		// Caret ranges.
+		{rng: "^1.2.3", satisfied: []string{"1.2.3", "1.9.9"}, unsatisfied: []string{"1.2.2", "2.0.0", "2.0.0-0", "1.3.0-beta"}},
+		{rng: "^0.2.3", satisfied: []string{"0.2.3", "0.2.9"}, unsatisfied: []string{"0.2.2", "0.3.0"}},
+		{rng: "^0.0.3", satisfied: []string{"0.0.3"}, unsatisfied: []string{"0.0.4"}},
+		{rng: "^1.2", satisfied: []string{"1.2.0", "1.9.9"}, unsatisfied: []string{"2.0.0"}},
+		{rng: "^0.0", satisfied: []string{"0.0.0", "0.0.9"}, unsatisfied: []string{"0.1.0"}},
+		{rng: "^0.x", satisfied: []string{"0.0.0", "0.9.9"}, unsatisfied: []string{"1.0.0"}},
+		{rng: "^1.2.3-beta.2", satisfied: []string{"1.2.3-beta.2", "1.2.3-beta.4", "1.2.3", "1.9.9"}, unsatisfied: []string{"1.2.3-beta.1", "1.2.4-beta.2", "2.0.0"}},
+		// Primitive comparators.
+		{rng: ">1.2.3", satisfied: []string{"1.2.4", "2.0.0"}, unsatisfied: []string{"1.2.3"}},
+		{rng: ">=1.2.3", satisfied: []string{"1.2.3", "2.0.0"}, unsatisfied: []string{"1.2.2"}},
+		{rng: "<1.2.3", satisfied: []string{"1.2.2", "0.0.0"}, unsatisfied: []string{"1.2.3", "1.2.3-beta"}},
+		{rng: "<=1.2.3", satisfied: []string{"1.2.3"}, unsatisfied: []string{"1.2.4"}},
+		{rng: ">1", satisfied: []string{"2.0.0"}, unsatisfied: []string{"1.9.9"}},
+		{rng: ">1.2", satisfied: []string{"1.3.0"}, unsatisfied: []string{"1.2.9"}},
+		{rng: "<1.2", satisfied: []string{"1.1.9"}, unsatisfied: []string{"1.2.0"}},
+		{rng: "<=1.2", satisfied: []string{"1.2.9"}, unsatisfied: []string{"1.3.0"}},
+		{rng: ">=1.2.3 <2.0.0", satisfied: []string{"1.2.3", "1.9.9"}, unsatisfied: []string{"1.2.2", "2.0.0"}},
+		{rng: ">= 1.2.3 < 2", satisfied: []string{"1.2.3", "1.9.9"}, unsatisfied: []string{"1.2.2", "2.0.0"}},
+		{rng: ">=1.0.0-rc.1", satisfied: []string{"1.0.0-rc.1", "1.0.0-rc.2", "1.0.0", "2.0.0"}, unsatisfied: []string{"1.0.0-beta", "2.0.0-rc.1"}},
+		// Hyphen ranges.
+		{rng: "1.2.3 - 2.3.4", satisfied: []string{"1.2.3", "2.3.4"}, unsatisfied: []string{"1.2.2", "2.3.5"}},
+		{rng: "1.2 - 2.3", satisfied: []string{"1.2.0", "2.3.9"}, unsatisfied: []string{"1.1.9", "2.4.0"}},
+		{rng: "1 - 2", satisfied: []string{"1.0.0", "2.9.9"}, unsatisfied: []string{"0.9.9", "3.0.0"}},
+		// Unions.
+		{rng: "^1.2.3 || ^2.0.0", satisfied: []string{"1.2.3", "2.5.0"}, unsatisfied: []string{"1.2.2", "3.0.0"}},
+		{rng: "1.2.3 || >=3", satisfied: []string{"1.2.3", "3.0.0", "4.0.0"}, unsatisfied: []string{"1.2.4", "2.0.0"}},
+		{rng: "<1 || 1.2.3 - 1.2.5 || ~2.1", satisfied: []string{"0.9.0", "1.2.4", "2.1.7"}, unsatisfied: []string{"1.0.0", "1.2.6", "2.2.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.rng, func(t *testing.T) {
+			r, err := parseRange(test.rng)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, version := range test.satisfied {
+				v, err := parseSemver(version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !r.satisfies(v) {
+					t.Errorf("expected %s to satisfy %q", version, test.rng)
+				}
+			}
+			for _, version := range test.unsatisfied {
+				v, err := parseSemver(version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.satisfies(v) {
+					t.Errorf("expected %s not to satisfy %q", version, test.rng)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	for _, input := range []string{"latest", "1.2.3.4", "^a.b.c", "1.2.3 - latest", "!1.2.3", "git+https://github.com/a/b.git"} {
+		t.Run(input, func(t *testing.T) {
+			if r, err := parseRange(input); err == nil {
+				t.Errorf("expected error, got %#v", r)
+			}
+		})
+	}
+}
+
+func TestMaxSatisfying(t *testing.T) {
+	versions := []string{"1.0.0", "1.2.0", "1.10.0", "2.0.0-beta.1", "2.0.0", "not-a-version"}
+	tests := []struct {
+		rng      string
+		expected string
+		ok       bool
+	}{
+		{rng: "^1.0.0", expected: "1.10.0", ok: true},
+		{rng: "~1.2.0", expected: "1.2.0", ok: true},
+		{rng: "*", expected: "2.0.0", ok: true},
+		{rng: ">=2.0.0-beta.1 <2.0.0", expected: "2.0.0-beta.1", ok: true},
+		{rng: "^3.0.0"},
+	}
+	for _, test := range tests {
+		t.Run(test.rng, func(t *testing.T) {
+			r, err := parseRange(test.rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, ok := r.maxSatisfying(versions)
+			if ok != test.ok || actual != test.expected {
+				t.Errorf("expected %q, %v, got %q, %v", test.expected, test.ok, actual, ok)
+			}
+		})
+	}
+}
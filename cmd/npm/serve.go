@@ -0,0 +1,228 @@
+package npm
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+type ServeArguments struct {
+	// Directory containing the exported tarballs, e.g. package/npm.
+	Directory string
+	// Address to listen on, e.g. :8080
+	Address string
+	Log     *slog.Logger
+}
+
+// Serve indexes the tarballs in the directory, and serves them as a read-only npm registry.
+func Serve(args ServeArguments) error {
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	log.Info("Indexing tarballs", slog.String("directory", args.Directory))
+	s, err := newRegistryServer(args.Directory, log)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Listening", slog.String("address", args.Address), slog.Int("packages", len(s.packages)))
+	server := &http.Server{
+		Addr:              args.Address,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// registryServer serves the packuments and tarballs of an exported npm directory.
+type registryServer struct {
+	log *slog.Logger
+	// packages maps the package name to its versions.
+	packages map[string]map[string]*servedVersion
+}
+
+type servedVersion struct {
+	Manifest  map[string]any
+	FileName  string
+	Integrity string
+	Shasum    string
+}
+
+func newRegistryServer(dir string, log *slog.Logger) (s *registryServer, err error) {
+	s = &registryServer{
+		log:      log,
+		packages: make(map[string]map[string]*servedVersion),
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".tgz") {
+			return nil
+		}
+		if err = s.add(p); err != nil {
+			// A single bad tarball shouldn't prevent the rest from being served.
+			log.Warn("Skipping tarball", slog.String("file", p), slog.Any("error", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index tarballs: %w", err)
+	}
+	return s, nil
+}
+
+func (s *registryServer) add(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	manifest, err := readPackageJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return fmt.Errorf("package.json is missing name or version")
+	}
+	integrity, err := hashReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	shasum := sha1.Sum(data)
+
+	versions, ok := s.packages[name]
+	if !ok {
+		versions = make(map[string]*servedVersion)
+		s.packages[name] = versions
+	}
+	if _, ok := versions[version]; ok {
+		return fmt.Errorf("duplicate of %s@%s", name, version)
+	}
+	versions[version] = &servedVersion{
+		Manifest:  manifest,
+		FileName:  fileName,
+		Integrity: integrity,
+		Shasum:    hex.EncodeToString(shasum[:]),
+	}
+	return nil
+}
+
+func (s *registryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "read-only registry", http.StatusMethodNotAllowed)
+		return
+	}
+	// Scoped packages are requested as /@scope%2fname, so use the escaped path.
+	p, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if name, fileName, ok := strings.Cut(p, "/-/"); ok {
+		s.serveTarball(w, r, name, fileName)
+		return
+	}
+	s.servePackument(w, r, p)
+}
+
+func (s *registryServer) servePackument(w http.ResponseWriter, r *http.Request, name string) {
+	versions, ok := s.packages[name]
+	if !ok {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// The tarball URLs are absolute, based on the host that npm used to make the request.
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	baseURL := scheme + "://" + r.Host
+
+	docs := make(map[string]any, len(versions))
+	versionNames := make([]string, 0, len(versions))
+	for version, v := range versions {
+		doc := make(map[string]any, len(v.Manifest)+2)
+		for k, value := range v.Manifest {
+			doc[k] = value
+		}
+		doc["_id"] = name + "@" + version
+		doc["dist"] = map[string]any{
+			"integrity": v.Integrity,
+			"shasum":    v.Shasum,
+			"tarball":   baseURL + "/" + name + "/-/" + path.Base(name) + "-" + version + ".tgz",
+		}
+		docs[version] = doc
+		versionNames = append(versionNames, version)
+	}
+	sort.Strings(versionNames)
+	latest, ok := latestVersion(versionNames)
+	if !ok {
+		latest = versionNames[len(versionNames)-1]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"_id":  name,
+		"name": name,
+		"dist-tags": map[string]string{
+			"latest": latest,
+		},
+		"versions": docs,
+	})
+	if err != nil {
+		s.log.Warn("Failed to write packument", slog.String("name", name), slog.Any("error", err))
+	}
+}
+
+func (s *registryServer) serveTarball(w http.ResponseWriter, r *http.Request, name, fileName string) {
+	version, ok := strings.CutSuffix(strings.TrimPrefix(fileName, path.Base(name)+"-"), ".tgz")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	v, ok := s.packages[name][version]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(v.FileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "failed to open tarball", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to open tarball", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, fileName, stat.ModTime(), f)
+}
@@ -0,0 +1,186 @@
+package npm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"log/slog"
+)
+
+func TestRegistryServer(t *testing.T) {
+	dir := t.TempDir()
+	tarballs := map[string]string{
+		"a@1.0.0":        writeTarball(t, dir, "a", "1.0.0"),
+		"a@2.0.0-beta.1": writeTarball(t, dir, "a", "2.0.0-beta.1"),
+		"a@1.1.0-file":   writeTarball(t, dir, "a", "1.1.0-file"),
+		"@scope/b@1.0.0": writeTarball(t, dir, "@scope/b", "1.0.0"),
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "invalid.tgz"), []byte("not a tarball"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newRegistryServer(dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		// expectedTarball is the tarball that is served.
+		expectedTarball string
+		// expectedVersions are the versions of the packument that is served, and
+		// expectedLatest is its latest tag.
+		expectedVersions []string
+		expectedLatest   string
+	}{
+		{
+			name:             "packument",
+			path:             "/a",
+			expectedStatus:   http.StatusOK,
+			expectedVersions: []string{"1.0.0", "1.1.0-file", "2.0.0-beta.1"},
+			expectedLatest:   "1.0.0",
+		},
+		{
+			name:             "scoped packument",
+			path:             "/@scope%2fb",
+			expectedStatus:   http.StatusOK,
+			expectedVersions: []string{"1.0.0"},
+			expectedLatest:   "1.0.0",
+		},
+		{
+			name:             "scoped packument with an upper case escape",
+			path:             "/@scope%2Fb",
+			expectedStatus:   http.StatusOK,
+			expectedVersions: []string{"1.0.0"},
+			expectedLatest:   "1.0.0",
+		},
+		{
+			name:            "tarball",
+			path:            "/a/-/a-2.0.0-beta.1.tgz",
+			expectedStatus:  http.StatusOK,
+			expectedTarball: tarballs["a@2.0.0-beta.1"],
+		},
+		{
+			name:            "scoped tarball",
+			path:            "/@scope/b/-/b-1.0.0.tgz",
+			expectedStatus:  http.StatusOK,
+			expectedTarball: tarballs["@scope/b@1.0.0"],
+		},
+		{
+			name:            "scoped tarball with an escaped name",
+			path:            "/@scope%2fb/-/b-1.0.0.tgz",
+			expectedStatus:  http.StatusOK,
+			expectedTarball: tarballs["@scope/b@1.0.0"],
+		},
+		{
+			name:           "missing package",
+			path:           "/missing",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "missing version",
+			path:           "/a/-/a-3.0.0.tgz",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "tarball of another package",
+			path:           "/a/-/b-1.0.0.tgz",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "publish",
+			method:         http.MethodPut,
+			path:           "/a",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, resp.StatusCode, body)
+			}
+
+			if test.expectedTarball != "" {
+				expected, err := os.ReadFile(test.expectedTarball)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(body, expected) {
+					t.Errorf("expected the content of %s", test.expectedTarball)
+				}
+			}
+
+			if test.expectedVersions != nil {
+				var p struct {
+					Name     string            `json:"name"`
+					DistTags map[string]string `json:"dist-tags"`
+					Versions map[string]struct {
+						Dist struct {
+							Integrity string `json:"integrity"`
+							Tarball   string `json:"tarball"`
+						} `json:"dist"`
+					} `json:"versions"`
+				}
+				if err = json.Unmarshal(body, &p); err != nil {
+					t.Fatal(err)
+				}
+				var versions []string
+				for version, v := range p.Versions {
+					versions = append(versions, version)
+					// The tarball URL must be served by the tarball route.
+					resp, err := http.Get(v.Dist.Tarball)
+					if err != nil {
+						t.Fatal(err)
+					}
+					data, err := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if resp.StatusCode != http.StatusOK {
+						t.Errorf("expected status %d for %s, got %d", http.StatusOK, v.Dist.Tarball, resp.StatusCode)
+					}
+					if err = validateReaderHash(bytes.NewReader(data), v.Dist.Integrity); err != nil {
+						t.Errorf("%s: %v", v.Dist.Tarball, err)
+					}
+				}
+				sort.Strings(versions)
+				if !reflect.DeepEqual(versions, test.expectedVersions) {
+					t.Errorf("expected versions %v, got %v", test.expectedVersions, versions)
+				}
+				if p.DistTags["latest"] != test.expectedLatest {
+					t.Errorf("expected latest %q, got %q", test.expectedLatest, p.DistTags["latest"])
+				}
+			}
+		})
+	}
+}
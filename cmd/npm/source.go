@@ -0,0 +1,562 @@
+package npm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Dependencies that aren't downloaded from a registry, i.e. git repositories and local
+// directories, are packed into tarballs in the output directory. The mapping from the lock file
+// resolved value to the tarball is written to package/npm/sources.json, so that lock files can be
+// rewritten to use the tarballs after they've been imported.
+//
+// Packed tarballs are published alongside the registry versions of the same package, so their
+// version is made distinct with a prerelease suffix, e.g. 1.0.0-git.<commit> or 1.0.0-file.
+
+// gitHosts maps the shorthand prefixes supported by npm to the clone URL of the host.
+var gitHosts = map[string]string{
+	"github:":    "https://github.com/",
+	"gitlab:":    "https://gitlab.com/",
+	"bitbucket:": "https://bitbucket.org/",
+}
+
+// isGitDependency returns true if the resolved value is a git URL, e.g.
+// git+ssh://git@github.com/user/repo.git#<commit>, or a hosted git shorthand, e.g.
+// github:user/repo#<commit>.
+func isGitDependency(resolved string) bool {
+	for _, prefix := range []string{"git+", "git://", "git@"} {
+		if strings.HasPrefix(resolved, prefix) {
+			return true
+		}
+	}
+	for prefix := range gitHosts {
+		if strings.HasPrefix(resolved, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGitDependency returns the URL to clone, and the commit, branch or tag to check out.
+func parseGitDependency(resolved string) (repoURL, ref string, err error) {
+	repoURL, ref, _ = strings.Cut(resolved, "#")
+	// Yarn uses #commit=<commit>, and npm uses #semver:<range> for tags, which isn't supported.
+	ref = strings.TrimPrefix(ref, "commit=")
+	if strings.HasPrefix(ref, "semver:") {
+		return "", "", fmt.Errorf("unsupported git dependency %q, the lock file should pin a commit", resolved)
+	}
+	for prefix, host := range gitHosts {
+		if shorthand, ok := strings.CutPrefix(repoURL, prefix); ok {
+			return host + strings.TrimSuffix(shorthand, ".git") + ".git", ref, nil
+		}
+	}
+	return strings.TrimPrefix(repoURL, "git+"), ref, nil
+}
+
+// isLocalDependency returns true if the package is a directory or tarball on disk, i.e. a file:
+// dependency, or a link to a workspace or directory in lockfileVersion 2 and 3.
+func isLocalDependency(pkg Package) bool {
+	return pkg.Link || strings.HasPrefix(pkg.Resolved, "file:")
+}
+
+// localPath returns the path of a local dependency, which is relative to the directory
+// containing the lock file.
+func localPath(pkg Package) string {
+	p := filepath.FromSlash(strings.TrimPrefix(pkg.Resolved, "file:"))
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(pkg.lockFileName), p)
+}
+
+// exportGitPackage clones the repository at the pinned commit, and packs it into
+// package/npm/<name>/<name>-<version>-git.<commit>.tgz. If the tarball already exists, it's used
+// without cloning, since the commit can't change.
+func exportGitPackage(pkg Package) (exported exportedPackage, cached bool, err error) {
+	repoURL, ref, err := parseGitDependency(pkg.Resolved)
+	if err != nil {
+		return exported, false, err
+	}
+	if pkg.Name == "" {
+		return exported, false, fmt.Errorf("unable to determine the package name of %q", pkg.Resolved)
+	}
+	if plumbing.IsHash(ref) {
+		// The version isn't known until the repository is cloned, so find the tarball by commit.
+		pattern, err := outputFileName(pkg.Name, path.Base(pkg.Name)+"-*git."+ref+".tgz")
+		if err != nil {
+			return exported, false, err
+		}
+		matches, _ := filepath.Glob(pattern)
+		for _, fileName := range matches {
+			if exported, err = readExportedPackage(fileName, pkg.Resolved); err == nil {
+				return exported, true, nil
+			}
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "impex-git-")
+	if err != nil {
+		return exported, false, err
+	}
+	defer os.RemoveAll(dir)
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL: repoURL,
+	})
+	if err != nil {
+		return exported, false, fmt.Errorf("failed to clone %q: %w", repoURL, err)
+	}
+	hash, err := resolveGitRef(repo, ref)
+	if err != nil {
+		return exported, false, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return exported, false, err
+	}
+	if err = worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return exported, false, fmt.Errorf("failed to check out %s: %w", hash, err)
+	}
+
+	manifest, err := readManifest(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return exported, false, err
+	}
+	version, _ := manifest["version"].(string)
+	if version == "" {
+		return exported, false, fmt.Errorf("%s: package.json is missing version", pkg.Resolved)
+	}
+	version = packedVersion(version, "git."+hash.String())
+	targetFileName, err := outputFileName(pkg.Name, path.Base(pkg.Name)+"-"+version+".tgz")
+	if err != nil {
+		return exported, false, err
+	}
+	if err = packDirectory(dir, targetFileName, version); err != nil {
+		return exported, false, err
+	}
+	exported, err = readExportedPackage(targetFileName, pkg.Resolved)
+	return exported, false, err
+}
+
+// resolveGitRef returns the commit for a commit hash, branch or tag. An empty ref is the
+// default branch.
+func resolveGitRef(repo *git.Repository, ref string) (hash plumbing.Hash, err error) {
+	if ref == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return hash, err
+		}
+		return head.Hash(), nil
+	}
+	// Branches only exist as remote branches after cloning.
+	for _, revision := range []string{ref, "origin/" + ref} {
+		h, err := repo.ResolveRevision(plumbing.Revision(revision))
+		if err == nil {
+			return *h, nil
+		}
+	}
+	return hash, fmt.Errorf("unable to find %q in the repository", ref)
+}
+
+// exportLocalPackage packs a local directory, or repacks a local tarball, into
+// package/npm/<name>/<name>-<version>-file.tgz. Local directories can change, so they're
+// packed every time.
+func exportLocalPackage(pkg Package) (exported exportedPackage, err error) {
+	source := localPath(pkg)
+	info, err := os.Stat(source)
+	if err != nil {
+		return exported, err
+	}
+	var manifest map[string]any
+	if info.IsDir() {
+		manifest, err = readManifest(filepath.Join(source, "package.json"))
+	} else {
+		var f *os.File
+		if f, err = os.Open(source); err == nil {
+			manifest, err = readPackageJSON(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		return exported, err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return exported, fmt.Errorf("%s: package.json is missing name or version", source)
+	}
+	version = packedVersion(version, "file")
+	targetFileName, err := outputFileName(name, path.Base(name)+"-"+version+".tgz")
+	if err != nil {
+		return exported, err
+	}
+	if info.IsDir() {
+		err = packDirectory(source, targetFileName, version)
+	} else {
+		err = repackTarball(source, targetFileName, version)
+	}
+	if err != nil {
+		return exported, err
+	}
+	return exportedPackage{Name: name, Version: version, FileName: targetFileName, Source: pkg.Resolved}, nil
+}
+
+// packedVersion returns the version of a packed git or local dependency, which is its
+// package.json version with a prerelease suffix, so that it can't be mistaken for the registry
+// version. Build metadata is dropped, since it's ignored when comparing versions.
+func packedVersion(version, suffix string) string {
+	version, _, _ = strings.Cut(version, "+")
+	if strings.Contains(version, "-") {
+		return version + "." + suffix
+	}
+	return version + "-" + suffix
+}
+
+// setPackageVersion replaces the top level version of a package.json document, without changing
+// the rest of the document.
+func setPackageVersion(data []byte, version string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var depth int
+	var key string
+	var expectKey bool
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("package.json is missing version")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package.json: %w", err)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			expectKey = depth == 1
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+			expectKey = depth == 1
+			continue
+		}
+		if depth != 1 {
+			continue
+		}
+		if expectKey {
+			key, _ = tok.(string)
+			expectKey = false
+			continue
+		}
+		expectKey = true
+		if _, ok := tok.(string); !ok || key != "version" {
+			continue
+		}
+		encoded, err := encodeJSONString(version)
+		if err != nil {
+			return nil, err
+		}
+		// The offset is the end of the key, so skip the colon and whitespace.
+		start := offset + bytes.IndexByte(data[offset:], '"')
+		return append(data[:start:start], append(encoded, data[dec.InputOffset():]...)...), nil
+	}
+}
+
+// readExportedPackage reads the name and version of a packed tarball.
+func readExportedPackage(fileName, source string) (exported exportedPackage, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return exported, err
+	}
+	defer f.Close()
+	manifest, err := readPackageJSON(f)
+	if err != nil {
+		return exported, err
+	}
+	name, _ := manifest["name"].(string)
+	version, _ := manifest["version"].(string)
+	if name == "" || version == "" {
+		return exported, fmt.Errorf("%s: package.json is missing name or version", fileName)
+	}
+	return exportedPackage{Name: name, Version: version, FileName: fileName, Source: source}, nil
+}
+
+func readManifest(fileName string) (manifest map[string]any, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", fileName, err)
+	}
+	return manifest, nil
+}
+
+// repackTarball copies an npm tarball, replacing the version in its package.json.
+func repackTarball(from, to, version string) (err error) {
+	f, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: failed to open gzip stream: %w", from, err)
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	var found bool
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: failed to read tarball: %w", from, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%s: failed to read tarball: %w", from, err)
+		}
+		// The root directory is usually "package", but not always.
+		if _, fileName, ok := strings.Cut(strings.TrimPrefix(hdr.Name, "./"), "/"); ok && fileName == "package.json" && !found {
+			if data, err = setPackageVersion(data, version); err != nil {
+				return fmt.Errorf("%s: %w", from, err)
+			}
+			hdr.Size = int64(len(data))
+			found = true
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: package.json not found in tarball", from)
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(to), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(to, buf.Bytes(), 0660)
+}
+
+// npmPackMtime is the modification time npm uses for every file in a packed tarball, so that
+// packing the same files produces the same integrity.
+var npmPackMtime = time.Date(1985, time.October, 26, 8, 15, 0, 0, time.UTC)
+
+// alwaysIgnored are files that npm never includes in a packed tarball.
+var alwaysIgnored = []string{".git", "node_modules", ".npmrc", "package-lock.json", ".DS_Store", "npm-debug.log", ".hg", ".svn", "CVS", ".npmignore", ".gitignore"}
+
+// alwaysIncluded are files that npm includes in a packed tarball, even if they're not listed in
+// the files field of package.json.
+var alwaysIncluded = []string{"package.json", "README*", "LICENSE*", "LICENCE*"}
+
+// packDirectory writes the package in dir to a tarball in the same format as npm pack, with the
+// version in package.json replaced. Files are selected using the files field of package.json,
+// and .npmignore, falling back to .gitignore, in each directory (see packFilter).
+// Lifecycle scripts, e.g. prepare, aren't run, so packages that need to be built before they're
+// packed will be missing their build output.
+func packDirectory(dir, targetFileName, version string) (err error) {
+	manifest, err := readManifest(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return err
+	}
+	include, err := packFilter(dir, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to read ignore files in %s: %w", dir, err)
+	}
+
+	var fileNames []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if contains(alwaysIgnored, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			// Symlinks aren't included by npm pack.
+			return nil
+		}
+		if include(rel) {
+			fileNames = append(fileNames, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list files in %s: %w", dir, err)
+	}
+	sort.Strings(fileNames)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, fileName := range fileNames {
+		if fileName == "package.json" {
+			err = addPackageJSONToTar(tw, dir, version)
+		} else {
+			err = addFileToTar(tw, dir, fileName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(targetFileName), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(targetFileName, buf.Bytes(), 0660)
+}
+
+func addFileToTar(tw *tar.Writer, dir, fileName string) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(fileName)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var mode int64 = 0644
+	if info.Mode()&0111 != 0 {
+		mode = 0755
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "package/" + fileName,
+		Mode:     mode,
+		Size:     info.Size(),
+		ModTime:  npmPackMtime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// addPackageJSONToTar adds the package.json in dir, with its version replaced.
+func addPackageJSONToTar(tw *tar.Writer, dir, version string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return err
+	}
+	if data, err = setPackageVersion(data, version); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "package/package.json",
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  npmPackMtime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// packageSource records the tarball that a git or local dependency was packed into.
+type packageSource struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Tarball is the path of the tarball relative to the output directory.
+	Tarball   string `json:"tarball"`
+	Integrity string `json:"integrity"`
+}
+
+// writeSources adds the packed packages to <dir>/sources.json, keyed by the resolved value in
+// the lock file, e.g. git+ssh://git@github.com/user/repo.git#<commit> or file:../lib
+func writeSources(dir string, exported []exportedPackage) (err error) {
+	sources, err := readSources(dir)
+	if err != nil {
+		return err
+	}
+
+	var updated bool
+	for _, pkg := range exported {
+		if pkg.Source == "" {
+			continue
+		}
+		integrity, err := hashFile(pkg.FileName)
+		if err != nil {
+			return err
+		}
+		tarball, err := filepath.Rel(dir, pkg.FileName)
+		if err != nil {
+			return err
+		}
+		sources[pkg.Source] = packageSource{
+			Name:      pkg.Name,
+			Version:   pkg.Version,
+			Tarball:   filepath.ToSlash(tarball),
+			Integrity: integrity,
+		}
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	data, err := json.MarshalIndent(sources, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "sources.json"), append(data, '\n'), 0660)
+}
+
+// readSources reads <dir>/sources.json, if it exists.
+func readSources(dir string) (sources map[string]packageSource, err error) {
+	fileName := filepath.Join(dir, "sources.json")
+	sources = make(map[string]packageSource)
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return sources, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", fileName, err)
+	}
+	return sources, nil
+}
@@ -0,0 +1,160 @@
+package npm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackedVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		suffix   string
+		expected string
+	}{
+		{version: "1.0.0", suffix: "file", expected: "1.0.0-file"},
+		{version: "1.0.0", suffix: "git.0123456789abcdef", expected: "1.0.0-git.0123456789abcdef"},
+		{version: "1.0.0-beta.1", suffix: "file", expected: "1.0.0-beta.1.file"},
+		{version: "1.0.0+build.5", suffix: "file", expected: "1.0.0-file"},
+	}
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			actual := packedVersion(test.version, test.suffix)
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+			if !isValidSemver(actual) {
+				t.Errorf("expected %q to be a valid version", actual)
+			}
+		})
+	}
+}
+
+func TestSetPackageVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		manifest    string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "formatting is preserved",
+			manifest: "{\n  \"name\": \"a\",\n  \"version\" : \"1.0.0\",\n  \"main\": \"index.js\"\n}\n",
+			expected: "{\n  \"name\": \"a\",\n  \"version\" : \"1.0.0-file\",\n  \"main\": \"index.js\"\n}\n",
+		},
+		{
+			name:     "nested versions aren't changed",
+			manifest: `{"name":"a","engines":{"version":"1"},"config":[{"version":"2"},"version"],"version":"1.0.0"}`,
+			expected: `{"name":"a","engines":{"version":"1"},"config":[{"version":"2"},"version"],"version":"1.0.0-file"}`,
+		},
+		{
+			name:        "missing version",
+			manifest:    `{"name":"a","engines":{"version":"1"}}`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := setPackageVersion([]byte(test.manifest), "1.0.0-file")
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(actual) != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExportLocalPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolved string
+		expected string
+	}{
+		{
+			name:     "directory",
+			resolved: "file:lib",
+			expected: `{"name":"lib","version":"1.0.0-file","description":"test"}`,
+		},
+		{
+			name:     "tarball",
+			resolved: "file:lib/lib-1.0.0.tgz",
+			expected: `{"name":"lib","version":"1.0.0-file","description":"test"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTarball(t, dir, "lib", "1.0.0")
+			manifest := `{"name":"lib","version":"1.0.0","description":"test"}`
+			if err := os.WriteFile(filepath.Join(dir, "lib", "package.json"), []byte(manifest), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Packages are exported relative to the working directory.
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			exported, err := exportLocalPackage(Package{Resolved: test.resolved, lockFileName: filepath.Join(dir, "package-lock.json")})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if exported.Version != "1.0.0-file" {
+				t.Errorf("expected version %q, got %q", "1.0.0-file", exported.Version)
+			}
+			if expected := "package/npm/lib/lib-1.0.0-file.tgz"; exported.FileName != expected {
+				t.Errorf("expected file name %q, got %q", expected, exported.FileName)
+			}
+			if actual := readTarballFile(t, exported.FileName, "package/package.json"); actual != test.expected {
+				t.Errorf("expected package.json %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+// readTarballFile returns the content of a file in a tarball.
+func readTarballFile(t *testing.T, fileName, name string) string {
+	t.Helper()
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			t.Fatalf("%s not found in %s", name, fileName)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
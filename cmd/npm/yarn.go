@@ -0,0 +1,526 @@
+package npm
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYarnBerryLockFile returns true if the yarn.lock file is in the YAML format used by
+// Yarn 2 and above, rather than the custom format used by Yarn classic.
+func isYarnBerryLockFile(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("__metadata:")) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseYarnLockFile(fileName string, r *registry) (lockFile NPMLock, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	if isYarnBerryLockFile(data) {
+		return parseYarnBerryLockFile(data, r, yarnCacheDirectories(filepath.Dir(fileName)))
+	}
+	return parseYarnClassicLockFile(data)
+}
+
+// parseYarnClassicLockFile parses the Yarn classic (v1) lock file format, e.g.
+//
+//	"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4":
+//	  version "7.10.4"
+//	  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.10.4.tgz#168da1a36e90da68ae8d49c0f1b48c7c6249213a"
+//	  integrity sha512-vG6SvB6oYEhvgisZNFRmRCUkLz11c7rp+tbNTynGqc6mS1d5ATd/sGyV3W0KZZnXaO2tkLFa20qR4gg2ApVdOg==
+//	  dependencies:
+//	    "@babel/highlight" "^7.10.4"
+func parseYarnClassicLockFile(data []byte) (lockFile NPMLock, err error) {
+	lockFile.Packages = make(map[string]Package)
+	var key string
+	var pkg Package
+	addPackage := func() error {
+		if key == "" {
+			return nil
+		}
+		if err := normalizeYarnClassicPackage(&pkg); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		lockFile.Packages[key] = pkg
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		switch {
+		case indent == 0:
+			// A new entry, keyed by one or more comma separated descriptors.
+			if err = addPackage(); err != nil {
+				return
+			}
+			key = strings.TrimSuffix(trimmed, ":")
+			descriptor := unquoteYarnValue(strings.TrimSpace(strings.Split(key, ",")[0]))
+			pkg = Package{
+				Name: yarnDescriptorName(descriptor),
+			}
+			// Local directories and tarballs don't have a resolved field, so use the descriptor.
+			if _, rng := splitNameAndRange(descriptor); strings.HasPrefix(rng, "file:") {
+				pkg.Resolved = rng
+			}
+		case indent == 2:
+			if key == "" {
+				return lockFile, fmt.Errorf("line %d: field outside of an entry", lineNumber)
+			}
+			field, value, _ := strings.Cut(trimmed, " ")
+			value = unquoteYarnValue(strings.TrimSpace(value))
+			switch unquoteYarnValue(field) {
+			case "version":
+				pkg.Version = value
+			case "resolved":
+				pkg.Resolved = value
+			case "integrity":
+				pkg.Integrity = value
+			}
+		}
+		// Deeper indentation is the contents of dependencies and optionalDependencies, which isn't required.
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	err = addPackage()
+	return
+}
+
+// normalizeYarnClassicPackage removes the sha1 fragment from the resolved URL, using it as the
+// integrity if the lock file predates yarn's integrity field.
+func normalizeYarnClassicPackage(pkg *Package) error {
+	if pkg.Resolved == "" {
+		return nil
+	}
+	u, err := url.Parse(pkg.Resolved)
+	if err != nil {
+		return fmt.Errorf("invalid resolved URL %q: %w", pkg.Resolved, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	shasum := u.Fragment
+	u.Fragment = ""
+	pkg.Resolved = u.String()
+	if pkg.Integrity == "" && shasum != "" {
+		pkg.Integrity, err = sha1HexToIntegrity(shasum)
+	}
+	return err
+}
+
+func unquoteYarnValue(s string) string {
+	if len(s) >= 2 && s[0] == '"' {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
+
+// yarnDescriptorName returns the package name from a descriptor such as "@scope/name@^1.0.0".
+// Aliases such as "alias@npm:name@^1.0.0" return the name of the aliased package.
+func yarnDescriptorName(descriptor string) string {
+	name, rng := splitNameAndRange(descriptor)
+	if target, ok := strings.CutPrefix(rng, "npm:"); ok && strings.Contains(target, "@") {
+		name, _ = splitNameAndRange(target)
+	}
+	return name
+}
+
+// splitNameAndRange splits "@scope/name@range" into name and range, taking into account the
+// leading @ of scoped packages.
+func splitNameAndRange(s string) (name, rng string) {
+	if len(s) < 2 {
+		return s, ""
+	}
+	i := strings.Index(s[1:], "@")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i+1], s[i+2:]
+}
+
+// yarnBerryEntry is an entry in a Yarn 2+ lock file, e.g.
+//
+//	"@babel/code-frame@npm:^7.0.0":
+//	  version: 7.22.5
+//	  resolution: "@babel/code-frame@npm:7.22.5"
+//	  checksum: 10c0/cfe12a...
+//	  languageName: node
+//	  linkType: hard
+type yarnBerryEntry struct {
+	Version    string `yaml:"version"`
+	Resolution string `yaml:"resolution"`
+	// Checksum is the sha512 of the zip archive in the yarn cache, prefixed by the cache key, not
+	// of the npm tarball, so downloads are verified against the archive.
+	Checksum     string `yaml:"checksum"`
+	LanguageName string `yaml:"languageName"`
+	LinkType     string `yaml:"linkType"`
+	// Conditions limit the platforms the package is installed on, e.g. "os=darwin & cpu=arm64".
+	Conditions string `yaml:"conditions"`
+}
+
+// yarnBerryMetadata is the __metadata entry of a Yarn 2+ lock file.
+type yarnBerryMetadata struct {
+	Version string `yaml:"version"`
+	// CacheKey identifies the format of the archives in the yarn cache, e.g. 10c0. Checksums
+	// written by Yarn 2 and 3 aren't prefixed by it.
+	CacheKey string `yaml:"cacheKey"`
+}
+
+// parseYarnBerryLockFile parses Yarn 2+ lock files. These don't contain tarball URLs or npm
+// compatible integrity, so the tarball URL is derived from the registry. The checksum is of
+// Yarn's cache archive, so registry packages are verified against the archive in one of the
+// cache directories.
+func parseYarnBerryLockFile(data []byte, r *registry, cacheDirectories []string) (lockFile NPMLock, err error) {
+	var entries map[string]yaml.Node
+	if err = yaml.Unmarshal(data, &entries); err != nil {
+		return lockFile, fmt.Errorf("failed to parse yarn lock file: %w", err)
+	}
+	var metadata yarnBerryMetadata
+	if node, ok := entries["__metadata"]; ok {
+		if err = node.Decode(&metadata); err != nil {
+			return lockFile, fmt.Errorf("__metadata: %w", err)
+		}
+	}
+	lockFile.Packages = make(map[string]Package)
+	for key, node := range entries {
+		if key == "__metadata" {
+			continue
+		}
+		var entry yarnBerryEntry
+		if err = node.Decode(&entry); err != nil {
+			return lockFile, fmt.Errorf("%s: %w", key, err)
+		}
+		pkg, ok := yarnBerryPackage(entry, r)
+		if !ok {
+			continue
+		}
+		if !pkg.Link && !isGitDependency(pkg.Resolved) {
+			checksum, err := parseYarnChecksum(entry.Checksum, metadata.CacheKey)
+			if err != nil {
+				return lockFile, fmt.Errorf("%s: %w", key, err)
+			}
+			pkg.yarnArchive = &yarnArchive{Checksum: checksum, Directories: cacheDirectories}
+		}
+		if entry.Conditions != "" {
+			// Yarn skips packages that don't match the conditions, so they're optional.
+			pkg.Optional = true
+			for _, condition := range strings.Split(entry.Conditions, "&") {
+				field, value, _ := strings.Cut(strings.TrimSpace(condition), "=")
+				switch field {
+				case "os":
+					pkg.Os = append(pkg.Os, value)
+				case "cpu":
+					pkg.Cpu = append(pkg.Cpu, value)
+				case "libc":
+					pkg.Libc = append(pkg.Libc, value)
+				}
+			}
+		}
+		lockFile.Packages[key] = pkg
+	}
+	return lockFile, nil
+}
+
+// yarnBerryPackage returns the package for packages from the npm registry, git repositories,
+// and workspaces. The root workspace, patches, links and portals are not exported.
+func yarnBerryPackage(entry yarnBerryEntry, r *registry) (pkg Package, ok bool) {
+	name, reference := splitNameAndRange(entry.Resolution)
+	switch {
+	case strings.HasPrefix(reference, "npm:"):
+		version := strings.TrimPrefix(reference, "npm:")
+		return Package{Name: name, Version: version, Resolved: r.tarballURL(name, version)}, true
+	case strings.Contains(reference, "#commit="):
+		// e.g. name@https://github.com/user/repo.git#commit=<commit>
+		resolved := reference
+		if !isGitDependency(resolved) {
+			resolved = "git+" + resolved
+		}
+		return Package{Name: name, Version: entry.Version, Resolved: resolved}, true
+	case strings.HasPrefix(reference, "workspace:") && reference != "workspace:.":
+		return Package{Name: name, Version: entry.Version, Resolved: strings.TrimPrefix(reference, "workspace:"), Link: true}, true
+	}
+	return pkg, false
+}
+
+// yarnChecksum is the checksum of a package in a Yarn 2+ lock file, e.g. 10c0/cfe12a..., which is
+// the sha512 of the zip archive that Yarn stores in its cache.
+type yarnChecksum struct {
+	CacheKey string
+	// SHA512 is hex encoded.
+	SHA512 string
+}
+
+func (c yarnChecksum) String() string {
+	if c.CacheKey == "" {
+		return c.SHA512
+	}
+	return c.CacheKey + "/" + c.SHA512
+}
+
+// parseYarnChecksum parses a checksum, which is prefixed by the cache key since Yarn 4. Lock files
+// written by Yarn 2 and 3 use the cacheKey of the lock file's __metadata.
+func parseYarnChecksum(s, cacheKey string) (c yarnChecksum, err error) {
+	if s == "" {
+		return c, fmt.Errorf("no checksum in the yarn lock file, so the package can't be verified")
+	}
+	c.CacheKey, c.SHA512, _ = strings.Cut(s, "/")
+	if c.SHA512 == "" {
+		c.CacheKey, c.SHA512 = cacheKey, s
+	}
+	if b, err := hex.DecodeString(c.SHA512); err != nil || len(b) != sha512.Size {
+		return c, fmt.Errorf("invalid checksum %q, expected a hex encoded sha512", s)
+	}
+	c.SHA512 = strings.ToLower(c.SHA512)
+	return c, nil
+}
+
+// yarnArchive locates the archive of a Yarn 2+ package in the yarn cache.
+type yarnArchive struct {
+	Checksum yarnChecksum
+	// Directories are the yarn cache directories to search.
+	Directories []string
+}
+
+// yarnCacheDirectories returns the directories that Yarn 2+ may have cached the archives of a
+// project in: YARN_CACHE_FOLDER, the cacheFolder of the project's .yarnrc.yml, or its default
+// .yarn/cache, and the global cache.
+func yarnCacheDirectories(projectDirectory string) (dirs []string) {
+	if dir := os.Getenv("YARN_CACHE_FOLDER"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	var rc struct {
+		CacheFolder  string `yaml:"cacheFolder"`
+		GlobalFolder string `yaml:"globalFolder"`
+	}
+	if data, err := os.ReadFile(filepath.Join(projectDirectory, ".yarnrc.yml")); err == nil {
+		// An invalid .yarnrc.yml only means that the default directories are searched.
+		_ = yaml.Unmarshal(data, &rc)
+	}
+	cacheFolder := rc.CacheFolder
+	if cacheFolder == "" {
+		cacheFolder = ".yarn/cache"
+	}
+	if !filepath.IsAbs(cacheFolder) {
+		cacheFolder = filepath.Join(projectDirectory, cacheFolder)
+	}
+	dirs = append(dirs, cacheFolder)
+	globalFolder := rc.GlobalFolder
+	if globalFolder == "" {
+		globalFolder = os.Getenv("YARN_GLOBAL_FOLDER")
+	}
+	if globalFolder == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			globalFolder = filepath.Join(home, ".yarn", "berry")
+		}
+	}
+	if globalFolder != "" {
+		dirs = append(dirs, filepath.Join(globalFolder, "cache"))
+	}
+	return dirs
+}
+
+// find returns the archive of the package in the yarn cache whose sha512 matches the checksum.
+// Archives are named after the package, e.g. @babel-code-frame-npm-7.22.5-cfe12a4d27-10c0.zip
+func (a *yarnArchive) find(name, version string) (fileName string, err error) {
+	prefix := strings.Replace(name, "/", "-", 1) + "-npm-" + version + "-"
+	for _, dir := range a.Directories {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || !strings.HasSuffix(entry.Name(), ".zip") {
+				continue
+			}
+			sum, err := hashFileSHA512(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return "", err
+			}
+			if sum == a.Checksum.SHA512 {
+				return filepath.Join(dir, entry.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no archive in the yarn cache matches checksum %s from the yarn lock file, run yarn install to populate the cache (searched %s)", a.Checksum, strings.Join(a.Directories, ", "))
+}
+
+// exportYarnBerryPackage downloads a registry package of a Yarn 2+ lock file into the output
+// directory, unless it has already been downloaded. The integrity reported by the registry only
+// checks the download. The tarball is trusted because its files match the archive in the yarn
+// cache, whose sha512 is the lock file checksum.
+func exportYarnBerryPackage(r *registry, pkg Package, targetFileName string) (cached bool, err error) {
+	// Find the archive first, so that packages missing from the cache aren't downloaded.
+	archiveFileName, err := pkg.yarnArchive.find(pkg.Name, pkg.Version)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(targetFileName); err == nil && verifyYarnArchive(targetFileName, archiveFileName, pkg.Name) == nil {
+		return true, nil
+	}
+	integrity, err := r.getIntegrity(pkg.Name, pkg.Version)
+	if err != nil {
+		return false, err
+	}
+	if err = download(r, pkg.Resolved, targetFileName, integrity); err != nil {
+		return false, err
+	}
+	if err = verifyYarnArchive(targetFileName, archiveFileName, pkg.Name); err != nil {
+		os.Remove(targetFileName)
+		return false, err
+	}
+	return false, nil
+}
+
+// verifyYarnArchive checks that the tarball contains the same files as the package's archive in
+// the yarn cache.
+func verifyYarnArchive(tarballFileName, archiveFileName, name string) error {
+	expected, err := hashYarnArchiveFiles(archiveFileName, name)
+	if err != nil {
+		return err
+	}
+	actual, err := hashTarballFiles(tarballFileName)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs error
+	for _, name := range names {
+		e, inArchive := expected[name]
+		a, inTarball := actual[name]
+		switch {
+		case !inTarball:
+			errs = errors.Join(errs, fmt.Errorf("%s is in the yarn cache archive %s, but missing", name, archiveFileName))
+		case !inArchive:
+			errs = errors.Join(errs, fmt.Errorf("%s is not in the yarn cache archive %s", name, archiveFileName))
+		case e != a:
+			errs = errors.Join(errs, fmt.Errorf("%s doesn't match the yarn cache archive %s", name, archiveFileName))
+		}
+	}
+	return errs
+}
+
+// hashYarnArchiveFiles returns the sha512 of each file of the package in a yarn cache archive,
+// keyed by the path relative to the package directory, node_modules/<name>/.
+func hashYarnArchiveFiles(fileName, name string) (files map[string]string, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open yarn cache archive: %w", err)
+	}
+	defer zr.Close()
+	prefix := "node_modules/" + name + "/"
+	files = make(map[string]string)
+	for _, f := range zr.File {
+		p, ok := strings.CutPrefix(f.Name, prefix)
+		if !ok || !f.Mode().IsRegular() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read %s: %w", fileName, f.Name, err)
+		}
+		files[p], err = hashReaderHex(r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read %s: %w", fileName, f.Name, err)
+		}
+	}
+	return files, nil
+}
+
+// hashTarballFiles returns the sha512 of each file in an npm tarball, keyed by the path relative
+// to the root directory, which is usually "package".
+func hashTarballFiles(fileName string) (files map[string]string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to open gzip stream: %w", fileName, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files = make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read tarball: %w", fileName, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		_, p, ok := strings.Cut(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if !ok {
+			continue
+		}
+		if files[p], err = hashReaderHex(tr); err != nil {
+			return nil, fmt.Errorf("%s: failed to read %s: %w", fileName, hdr.Name, err)
+		}
+	}
+}
+
+func hashFileSHA512(fileName string) (sum string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReaderHex(f)
+}
+
+// hashReaderHex returns the hex encoded sha512 of the reader.
+func hashReaderHex(r io.Reader) (sum string, err error) {
+	hasher := sha512.New()
+	if _, err = io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,331 @@
+package npm
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYarnClassicLockFile(t *testing.T) {
+	lockFile := `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4":
+  version "7.10.4"
+  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.10.4.tgz#168da1a36e90da68ae8d49c0f1b48c7c6249213a"
+  integrity sha512-vG6SvB6oYEhvgisZNFRmRCUkLz11c7rp+tbNTynGqc6mS1d5ATd/sGyV3W0KZZnXaO2tkLFa20qR4gg2ApVdOg==
+  dependencies:
+    "@babel/highlight" "^7.10.4"
+
+ms@2.1.2, ms@^2.1.1:
+  version "2.1.2"
+  resolved "https://registry.yarnpkg.com/ms/-/ms-2.1.2.tgz#d09d1f357b443f493382a8eb3ccd183872ae6009"
+
+"alias@npm:ms@^2.0.0":
+  version "2.0.0"
+  resolved "https://registry.yarnpkg.com/ms/-/ms-2.0.0.tgz#5608aeadfc00be6c2901df5f9861788de0d597c8"
+
+"lib@file:../lib":
+  version "1.0.0"
+`
+	expected := map[string]Package{
+		`"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4"`: {
+			Name:      "@babel/code-frame",
+			Version:   "7.10.4",
+			Resolved:  "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.10.4.tgz",
+			Integrity: "sha512-vG6SvB6oYEhvgisZNFRmRCUkLz11c7rp+tbNTynGqc6mS1d5ATd/sGyV3W0KZZnXaO2tkLFa20qR4gg2ApVdOg==",
+		},
+		// Lock files written before yarn 1.10 only have the sha1 in the resolved URL.
+		`ms@2.1.2, ms@^2.1.1`: {
+			Name:      "ms",
+			Version:   "2.1.2",
+			Resolved:  "https://registry.yarnpkg.com/ms/-/ms-2.1.2.tgz",
+			Integrity: "sha1-0J0fNXtEP0kzgqjrPM0YOHKuYAk=",
+		},
+		`"alias@npm:ms@^2.0.0"`: {
+			Name:      "ms",
+			Version:   "2.0.0",
+			Resolved:  "https://registry.yarnpkg.com/ms/-/ms-2.0.0.tgz",
+			Integrity: "sha1-VgiurfwAvmwpAd9fmGF4jeDVl8g=",
+		},
+		`"lib@file:../lib"`: {
+			Name:     "lib",
+			Version:  "1.0.0",
+			Resolved: "file:../lib",
+		},
+	}
+	actual, err := parseYarnClassicLockFile([]byte(lockFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual.Packages, expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual.Packages)
+	}
+}
+
+func TestParseYarnBerryLockFile(t *testing.T) {
+	checksum := strings.Repeat("0123456789abcdef", 8)
+	tests := []struct {
+		name     string
+		lockFile string
+		// expected maps the lock file key to the package, without the yarn archive.
+		expected         map[string]Package
+		expectedChecksum yarnChecksum
+		expectedErr      bool
+	}{
+		{
+			name: "checksum prefixed by the cache key",
+			lockFile: `__metadata:
+  version: 8
+  cacheKey: 10c0
+
+"@babel/code-frame@npm:^7.0.0, @babel/code-frame@npm:^7.10.4":
+  version: 7.22.5
+  resolution: "@babel/code-frame@npm:7.22.5"
+  checksum: 10c0/` + checksum + `
+  languageName: node
+  linkType: hard
+
+"app@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "app@workspace:."
+  languageName: unknown
+  linkType: soft
+`,
+			expected: map[string]Package{
+				"@babel/code-frame@npm:^7.0.0, @babel/code-frame@npm:^7.10.4": {
+					Name:     "@babel/code-frame",
+					Version:  "7.22.5",
+					Resolved: "https://registry.npmjs.org/@babel/code-frame/-/code-frame-7.22.5.tgz",
+				},
+			},
+			expectedChecksum: yarnChecksum{CacheKey: "10c0", SHA512: checksum},
+		},
+		{
+			name: "checksum without a cache key uses the metadata",
+			lockFile: `__metadata:
+  version: 6
+  cacheKey: 8
+
+"fsevents@npm:^2.3.2":
+  version: 2.3.3
+  resolution: "fsevents@npm:2.3.3"
+  checksum: ` + strings.ToUpper(checksum) + `
+  conditions: os=darwin
+  languageName: node
+  linkType: hard
+`,
+			expected: map[string]Package{
+				"fsevents@npm:^2.3.2": {
+					Name:     "fsevents",
+					Version:  "2.3.3",
+					Resolved: "https://registry.npmjs.org/fsevents/-/fsevents-2.3.3.tgz",
+					Optional: true,
+					Os:       []string{"darwin"},
+				},
+			},
+			expectedChecksum: yarnChecksum{CacheKey: "8", SHA512: checksum},
+		},
+		{
+			name: "missing checksum",
+			lockFile: `__metadata:
+  version: 8
+  cacheKey: 10c0
+
+"ms@npm:^2.1.1":
+  version: 2.1.3
+  resolution: "ms@npm:2.1.3"
+  languageName: node
+  linkType: hard
+`,
+			expectedErr: true,
+		},
+		{
+			name: "checksum that isn't a sha512",
+			lockFile: `__metadata:
+  version: 8
+  cacheKey: 10c0
+
+"ms@npm:^2.1.1":
+  version: 2.1.3
+  resolution: "ms@npm:2.1.3"
+  checksum: 10c0/0123456789abcdef
+  languageName: node
+  linkType: hard
+`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &registry{url: "https://registry.npmjs.org"}
+			actual, err := parseYarnBerryLockFile([]byte(test.lockFile), r, []string{"cache"})
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for key, pkg := range actual.Packages {
+				if pkg.yarnArchive == nil {
+					t.Fatalf("%s: expected a yarn archive", key)
+				}
+				if pkg.yarnArchive.Checksum != test.expectedChecksum {
+					t.Errorf("%s: expected checksum %v, got %v", key, test.expectedChecksum, pkg.yarnArchive.Checksum)
+				}
+				pkg.yarnArchive = nil
+				actual.Packages[key] = pkg
+			}
+			if !reflect.DeepEqual(actual.Packages, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, actual.Packages)
+			}
+		})
+	}
+}
+
+func TestYarnArchiveFind(t *testing.T) {
+	tests := []struct {
+		name        string
+		packageName string
+		// archives maps the cache directory to the package.json of the archive written to it.
+		archives    map[string]string
+		expectedDir string
+		expectedErr bool
+	}{
+		{
+			name:        "archive in the global cache",
+			packageName: "a",
+			archives:    map[string]string{"global": `{"name":"a"}`},
+			expectedDir: "global",
+		},
+		{
+			name:        "scoped package",
+			packageName: "@scope/a",
+			archives:    map[string]string{"project": `{"name":"@scope/a"}`},
+			expectedDir: "project",
+		},
+		{
+			name:        "missing archive",
+			packageName: "a",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := &yarnArchive{Directories: []string{filepath.Join(dir, "project"), filepath.Join(dir, "global")}}
+			// An archive of the same version with a different checksum is ignored.
+			writeYarnArchive(t, filepath.Join(dir, "project"), test.packageName, "1.0.0", map[string]string{"package.json": `{"modified":true}`})
+			sum := sha512.Sum512([]byte("missing"))
+			archive.Checksum = yarnChecksum{CacheKey: "10c0", SHA512: hex.EncodeToString(sum[:])}
+			for cacheDir, manifest := range test.archives {
+				checksum := writeYarnArchive(t, filepath.Join(dir, cacheDir), test.packageName, "1.0.0", map[string]string{"package.json": manifest})
+				archive.Checksum.SHA512 = checksum
+			}
+
+			fileName, err := archive.find(test.packageName, "1.0.0")
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if filepath.Dir(fileName) != filepath.Join(dir, test.expectedDir) {
+				t.Errorf("expected an archive in %q, got %q", test.expectedDir, fileName)
+			}
+		})
+	}
+}
+
+func TestVerifyYarnArchive(t *testing.T) {
+	// The package.json of the tarballs written by writeTarball.
+	manifest := `{"name":"a","version":"1.0.0","description":"test"}`
+	tests := []struct {
+		name        string
+		files       map[string]string
+		expectedErr bool
+	}{
+		{
+			name:  "matching files",
+			files: map[string]string{"package.json": manifest},
+		},
+		{
+			name:        "modified file",
+			files:       map[string]string{"package.json": strings.Replace(manifest, "test", "modified", 1)},
+			expectedErr: true,
+		},
+		{
+			name:        "file missing from the tarball",
+			files:       map[string]string{"package.json": manifest, "index.js": ""},
+			expectedErr: true,
+		},
+		{
+			name:        "file missing from the archive",
+			files:       map[string]string{"index.js": ""},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tarball := writeTarball(t, dir, "a", "1.0.0")
+			checksum := writeYarnArchive(t, filepath.Join(dir, "cache"), "a", "1.0.0", test.files)
+			archive, err := (&yarnArchive{Checksum: yarnChecksum{SHA512: checksum}, Directories: []string{filepath.Join(dir, "cache")}}).find("a", "1.0.0")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = verifyYarnArchive(tarball, archive, "a")
+			if test.expectedErr && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !test.expectedErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// writeYarnArchive writes a Yarn 2+ cache archive of a package to dir, with the files given by
+// name and content, and returns its checksum.
+func writeYarnArchive(t *testing.T, dir, name, version string, files map[string]string) (checksum string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for fileName, content := range files {
+		w, err := zw.Create("node_modules/" + name + "/" + fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha512.Sum512(buf.Bytes())
+	checksum = hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, strings.Replace(name, "/", "-", 1)+"-npm-"+version+"-"+checksum[:10]+"-10c0.zip")
+	if err := os.WriteFile(fileName, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return checksum
+}
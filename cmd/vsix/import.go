@@ -0,0 +1,186 @@
+package vsix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+type ImportArguments struct {
+	// Directory containing the exported extensions, e.g. package/vsix.
+	Directory string
+	// RegistryURL is the base URL of the Open VSX compatible registry to publish to,
+	// e.g. https://open-vsx.internal
+	RegistryURL string
+	// Token is a personal access token of a user allowed to publish to the registry.
+	Token string
+	// Client is the HTTP client used to talk to the registry, defaults to http.DefaultClient.
+	Client *http.Client
+	Log    *slog.Logger
+}
+
+// Import publishes the extensions in the directory to an Open VSX compatible registry, creating
+// namespaces as required. Versions that already exist are skipped.
+func Import(args ImportArguments) error {
+	start := time.Now()
+
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	o := openVSX{
+		baseURL: strings.TrimSuffix(args.RegistryURL, "/"),
+		token:   args.Token,
+		client:  args.Client,
+	}
+
+	// Find the extensions.
+	log.Info("Finding extensions", slog.String("directory", args.Directory))
+	var fileNames []string
+	err := filepath.WalkDir(args.Directory, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".vsix") {
+			fileNames = append(fileNames, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list extensions: %w", err)
+	}
+
+	// Publish each extension.
+	var errs error
+	var published, skipped int
+	namespaces := make(map[string]bool)
+	for _, fileName := range fileNames {
+		ok, err := o.publish(fileName, namespaces)
+		if err != nil {
+			log.Error("Failed to publish", slog.String("file", fileName), slog.Any("error", err))
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", fileName, err))
+			continue
+		}
+		if !ok {
+			log.Info("Skipping, already exists", slog.String("file", fileName))
+			skipped++
+			continue
+		}
+		log.Info("Published", slog.String("file", fileName))
+		published++
+	}
+
+	log.Info("Complete", slog.Int("total", len(fileNames)), slog.Int("published", published), slog.Int("skipped", skipped), slog.String("duration", time.Now().Sub(start).String()))
+	return errs
+}
+
+// publish uploads the VSIX to the registry, in the same way as `ovsx publish`. Namespaces that
+// have been checked, or created, are recorded in namespaces. It returns false if the version
+// already exists in the registry.
+func (o openVSX) publish(fileName string, namespaces map[string]bool) (ok bool, err error) {
+	m, err := readManifest(fileName)
+	if err != nil {
+		return false, err
+	}
+	if m.Publisher == "" || m.Name == "" || m.Version == "" {
+		return false, fmt.Errorf("package.json is missing publisher, name or version")
+	}
+	// Platform-specific builds of the same version are published separately, so the existing
+	// version must be for the same target platform. Without one, the registry returns any build
+	// of the version.
+	pm, err := readPackageManifest(fileName)
+	if err != nil {
+		return false, err
+	}
+	target := pm.Metadata.Identity.TargetPlatform
+	if target == "" {
+		target = "universal"
+	}
+
+	ext := extension{Publisher: m.Publisher, Name: m.Name, Version: m.Version}
+	existing, exists, err := o.get(ext, target)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for existing version: %w", err)
+	}
+	if exists && (existing.TargetPlatform == target || (target == "universal" && existing.isUniversal())) {
+		return false, nil
+	}
+
+	if !namespaces[m.Publisher] {
+		if err = o.createNamespace(m.Publisher); err != nil {
+			return false, err
+		}
+		namespaces[m.Publisher] = true
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	if err = o.post("/api/-/publish", "application/octet-stream", data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// createNamespace creates the namespace, i.e. the publisher, if it doesn't exist.
+func (o openVSX) createNamespace(name string) error {
+	from := o.baseURL + "/api/" + url.PathEscape(name)
+	resp, err := o.httpClient().Get(from)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("expected status OK for %q, but got %d", from, resp.StatusCode)
+	}
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+	if err = o.post("/api/-/namespace/create", "application/json", body); err != nil {
+		return fmt.Errorf("failed to create namespace %q: %w", name, err)
+	}
+	return nil
+}
+
+// post sends an authenticated request to the publish API. The token is passed as a query
+// parameter, as the ovsx CLI does, so it's left out of errors.
+func (o openVSX) post(path, contentType string, body []byte) error {
+	to := o.baseURL + path
+	req, err := http.NewRequest(http.MethodPost, to+"?"+url.Values{"token": {o.token}}.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		// The error contains the URL.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("POST %q: %w", to, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("expected success status for POST %q, but got %d: %s", to, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package vsix
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"log/slog"
+)
+
+// writeVSIX writes a VSIX containing the package.json and extension.vsixmanifest of an
+// extension, and returns its file name.
+func writeVSIX(t *testing.T, dir, publisher, name, version, targetPlatform string) string {
+	t.Helper()
+	fileName := filepath.Join(dir, vsixFileName(publisher, name, version, targetPlatform))
+	writeZip(t, fileName, map[string]string{
+		"extension/package.json": fmt.Sprintf(`{"publisher":%q,"name":%q,"version":%q}`, publisher, name, version),
+		"extension.vsixmanifest": fmt.Sprintf(`<PackageManifest><Metadata><Identity Id=%q Version=%q Publisher=%q TargetPlatform=%q/></Metadata></PackageManifest>`, name, version, publisher, targetPlatform),
+	})
+	return fileName
+}
+
+// fakeOpenVSX is an Open VSX registry holding published versions, keyed by
+// namespace/name/targetPlatform/version.
+type fakeOpenVSX struct {
+	token string
+
+	m          sync.Mutex
+	namespaces map[string]bool
+	versions   map[string]bool
+	published  []string
+}
+
+func (f *fakeOpenVSX) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	switch {
+	case r.Method == http.MethodPost:
+		if r.URL.Query().Get("token") != f.token {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/-/namespace/create":
+			body, _ := io.ReadAll(r.Body)
+			name := strings.Split(string(body), `"`)[3]
+			f.namespaces[name] = true
+		case "/api/-/publish":
+			// Read the uploaded VSIX, to record its identity.
+			tmp, err := os.CreateTemp("", "upload-*.vsix")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer os.Remove(tmp.Name())
+			io.Copy(tmp, r.Body)
+			tmp.Close()
+			pm, err := readPackageManifest(tmp.Name())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id := pm.Metadata.Identity
+			target := id.TargetPlatform
+			if target == "" {
+				target = "universal"
+			}
+			key := strings.Join([]string{id.Publisher, id.ID, target, id.Version}, "/")
+			f.versions[key] = true
+			f.published = append(f.published, key)
+		default:
+			http.NotFound(w, r)
+		}
+	case len(segments) == 1:
+		if !f.namespaces[segments[0]] {
+			http.NotFound(w, r)
+		}
+	case len(segments) == 4:
+		ns, name, target, version := segments[0], segments[1], segments[2], segments[3]
+		if !f.versions[r.URL.Path[len("/api/"):]] {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"namespace":%q,"name":%q,"version":%q,"targetPlatform":%q,"files":{"download":"https://example.com/%s.vsix"}}`, ns, name, version, target, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name           string
+		token          string
+		namespaces     []string
+		existing       []string
+		targetPlatform string
+		expectedErr    bool
+		expected       []string
+	}{
+		{
+			name:     "publishes new version and creates namespace",
+			token:    "secret",
+			expected: []string{"pub/ext/universal/1.0.0"},
+		},
+		{
+			name:       "skips existing version",
+			token:      "secret",
+			namespaces: []string{"pub"},
+			existing:   []string{"pub/ext/universal/1.0.0"},
+		},
+		{
+			name:       "publishes universal build when a platform build exists",
+			token:      "secret",
+			namespaces: []string{"pub"},
+			existing:   []string{"pub/ext/linux-x64/1.0.0"},
+			expected:   []string{"pub/ext/universal/1.0.0"},
+		},
+		{
+			name:           "publishes platform build when another platform build exists",
+			token:          "secret",
+			namespaces:     []string{"pub"},
+			existing:       []string{"pub/ext/linux-x64/1.0.0"},
+			targetPlatform: "win32-x64",
+			expected:       []string{"pub/ext/win32-x64/1.0.0"},
+		},
+		{
+			name:        "invalid token",
+			token:       "wrong",
+			namespaces:  []string{"pub"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := &fakeOpenVSX{
+				token:      "secret",
+				namespaces: make(map[string]bool),
+				versions:   make(map[string]bool),
+			}
+			for _, ns := range test.namespaces {
+				registry.namespaces[ns] = true
+			}
+			for _, key := range test.existing {
+				registry.versions[key] = true
+			}
+			server := httptest.NewServer(registry)
+			defer server.Close()
+
+			dir := t.TempDir()
+			writeVSIX(t, dir, "pub", "ext", "1.0.0", test.targetPlatform)
+
+			err := Import(ImportArguments{
+				Directory:   dir,
+				RegistryURL: server.URL,
+				Token:       test.token,
+				Log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if strings.Contains(err.Error(), test.token) {
+					t.Errorf("expected the token to be left out of the error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fmt.Sprint(registry.published) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v to be published, got %v", test.expected, registry.published)
+			}
+			if !registry.namespaces["pub"] {
+				t.Error("expected namespace pub to be created")
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package vsix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const marketplaceQueryURL = "https://marketplace.visualstudio.com/_apis/public/gallery/extensionquery"
+
+// marketplace is the Visual Studio Marketplace.
+type marketplace struct{}
+
+func (marketplace) name() string {
+	return sourceMarketplace
+}
+
+func (marketplace) resolve(ext extension, targets []string) (assets []asset, err error) {
+	if len(targets) == 0 {
+		// The signature is requested for the downloaded version, rather than the latest, since a
+		// new version may be published between the requests.
+		signatureURL := func(version string) string {
+			pinned := ext
+			pinned.Version = version
+			return galleryURL(pinned, assetTypeVSIXSignature)
+		}
+		return []asset{{Version: ext.Version, URL: galleryURL(ext, assetTypeVSIXPackage), SignatureURL: signatureURL}}, nil
+	}
+	me, err := queryExtension(ext)
+	if err != nil {
+		return nil, err
+	}
+	var errs error
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		v, ok := me.selectVersion(ext.Version, target)
+		if !ok {
+			errs = errors.Join(errs, fmt.Errorf("%s: no version found for target platform %s", ext.ID(), target))
+			continue
+		}
+		targetPlatform := v.TargetPlatform
+		if v.isUniversal() {
+			targetPlatform = ""
+		}
+		if seen[v.Version+"@"+targetPlatform] {
+			continue
+		}
+		seen[v.Version+"@"+targetPlatform] = true
+		from, ok := v.asset(assetTypeVSIXPackage)
+		if !ok {
+			errs = errors.Join(errs, fmt.Errorf("%s@%s: no VSIX package found for target platform %s", ext.ID(), v.Version, target))
+			continue
+		}
+		// Extensions published before signing was introduced don't have a signature.
+		signature, _ := v.asset(assetTypeVSIXSignature)
+		assets = append(assets, asset{Version: v.Version, TargetPlatform: targetPlatform, URL: from, SignatureURL: fixedURL(signature)})
+	}
+	return assets, errs
+}
+
+// galleryURL returns the URL of an asset of the extension, e.g. the VSIX, either for the pinned
+// version or the latest.
+func galleryURL(ext extension, assetType string) string {
+	version := ext.Version
+	if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("https://%s.gallery.vsassets.io/_apis/public/gallery/publisher/%s/extension/%s/%s/assetbyname/%s",
+		url.PathEscape(ext.Publisher),
+		url.PathEscape(ext.Publisher),
+		url.PathEscape(ext.Name),
+		url.PathEscape(version),
+		url.PathEscape(assetType))
+}
+
+// Target platforms supported by the Marketplace. Extensions without native code publish a single
+// universal VSIX instead.
+var targetPlatforms = []string{
+	"win32-x64", "win32-arm64",
+	"linux-x64", "linux-arm64", "linux-armhf",
+	"alpine-x64", "alpine-arm64",
+	"darwin-x64", "darwin-arm64",
+	"web",
+}
+
+func isTargetPlatform(target string) bool {
+	for _, tp := range targetPlatforms {
+		if tp == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Extension query flags, see https://github.com/microsoft/vscode/blob/main/src/vs/platform/extensionManagement/common/extensionGalleryService.ts
+const (
+	flagIncludeVersions          = 0x1
+	flagIncludeFiles             = 0x2
+	flagIncludeVersionProperties = 0x10
+	flagIncludeAssetURI          = 0x80
+	flagIncludeLatestVersionOnly = 0x200
+)
+
+// Extension query filter types.
+const (
+	filterTypeTag         = 1
+	filterTypeExtensionID = 4
+	filterTypeCategory    = 5
+	// filterTypeExtensionName filters by the extension identifier, e.g. golang.go
+	filterTypeExtensionName = 7
+	filterTypeSearchText    = 10
+)
+
+const (
+	assetTypeVSIXPackage   = "Microsoft.VisualStudio.Services.VSIXPackage"
+	assetTypeVSIXSignature = "Microsoft.VisualStudio.Services.VsixSignature"
+	propertyPreRelease     = "Microsoft.VisualStudio.Code.PreRelease"
+)
+
+type extensionQuery struct {
+	Filters []extensionQueryFilter `json:"filters"`
+	Flags   int                    `json:"flags"`
+}
+
+type extensionQueryFilter struct {
+	Criteria   []extensionQueryCriterion `json:"criteria"`
+	PageNumber int                       `json:"pageNumber"`
+	PageSize   int                       `json:"pageSize"`
+}
+
+type extensionQueryCriterion struct {
+	FilterType int    `json:"filterType"`
+	Value      string `json:"value"`
+}
+
+type extensionQueryResponse struct {
+	Results []extensionQueryResult `json:"results"`
+}
+
+type extensionQueryResult struct {
+	Extensions     []marketplaceExtension `json:"extensions"`
+	ResultMetadata []resultMetadata       `json:"resultMetadata,omitempty"`
+}
+
+// resultMetadata contains the total number of results, for paging.
+type resultMetadata struct {
+	MetadataType  string         `json:"metadataType"`
+	MetadataItems []metadataItem `json:"metadataItems"`
+}
+
+type metadataItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type marketplaceExtension struct {
+	ExtensionID      string               `json:"extensionId,omitempty"`
+	ExtensionName    string               `json:"extensionName"`
+	DisplayName      string               `json:"displayName,omitempty"`
+	ShortDescription string               `json:"shortDescription,omitempty"`
+	Publisher        marketplacePublisher `json:"publisher"`
+	// Versions are ordered from newest to oldest. Each target platform of a version is listed
+	// separately.
+	Versions    []marketplaceVersion `json:"versions"`
+	Categories  []string             `json:"categories,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Flags       string               `json:"flags,omitempty"`
+	LastUpdated string               `json:"lastUpdated,omitempty"`
+}
+
+type marketplacePublisher struct {
+	PublisherID   string `json:"publisherId,omitempty"`
+	PublisherName string `json:"publisherName"`
+	DisplayName   string `json:"displayName,omitempty"`
+}
+
+type marketplaceVersion struct {
+	Version string `json:"version"`
+	// TargetPlatform is empty for universal extensions.
+	TargetPlatform string                `json:"targetPlatform,omitempty"`
+	LastUpdated    string                `json:"lastUpdated,omitempty"`
+	AssetURI       string                `json:"assetUri,omitempty"`
+	FallbackURI    string                `json:"fallbackAssetUri,omitempty"`
+	Files          []marketplaceFile     `json:"files"`
+	Properties     []marketplaceProperty `json:"properties"`
+}
+
+type marketplaceFile struct {
+	AssetType string `json:"assetType"`
+	Source    string `json:"source"`
+}
+
+type marketplaceProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (v marketplaceVersion) isUniversal() bool {
+	return v.TargetPlatform == "" || v.TargetPlatform == "universal"
+}
+
+func (v marketplaceVersion) isPreRelease() bool {
+	for _, p := range v.Properties {
+		if p.Key == propertyPreRelease {
+			return p.Value == "true"
+		}
+	}
+	return false
+}
+
+// asset returns the URL of an asset, e.g. the VSIX package.
+func (v marketplaceVersion) asset(assetType string) (url string, ok bool) {
+	for _, f := range v.Files {
+		if f.AssetType == assetType {
+			return f.Source, true
+		}
+	}
+	return "", false
+}
+
+// queryExtension returns the versions of an extension published to the Marketplace.
+func queryExtension(ext extension) (me marketplaceExtension, err error) {
+	query := extensionQuery{
+		Filters: []extensionQueryFilter{
+			{
+				Criteria: []extensionQueryCriterion{
+					{FilterType: filterTypeExtensionName, Value: ext.ID()},
+				},
+				PageNumber: 1,
+				PageSize:   1,
+			},
+		},
+		Flags: flagIncludeVersions | flagIncludeFiles | flagIncludeVersionProperties | flagIncludeAssetURI,
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return me, err
+	}
+	req, err := http.NewRequest(http.MethodPost, marketplaceQueryURL, bytes.NewReader(body))
+	if err != nil {
+		return me, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json;api-version=3.0-preview.1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return me, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return me, fmt.Errorf("expected status OK for %q, but got %d", marketplaceQueryURL, resp.StatusCode)
+	}
+	var qr extensionQueryResponse
+	if err = json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+		return me, fmt.Errorf("%s: failed to parse extension query response: %w", ext.ID(), err)
+	}
+	for _, result := range qr.Results {
+		for _, me := range result.Extensions {
+			if strings.EqualFold(me.Publisher.PublisherName+"."+me.ExtensionName, ext.ID()) {
+				return me, nil
+			}
+		}
+	}
+	return me, fmt.Errorf("%s: extension not found in the Marketplace", ext.ID())
+}
+
+// selectVersion returns the version to download for a target platform: the pinned version, or
+// the newest release that isn't a pre-release. A build for the target platform is preferred, but
+// universal builds run on every platform.
+func (me marketplaceExtension) selectVersion(version, target string) (selected marketplaceVersion, ok bool) {
+	matches := func(v marketplaceVersion) bool {
+		if version != "" {
+			return v.Version == version
+		}
+		return !v.isPreRelease()
+	}
+	// The newest version with a matching build is used, since a version may be published for some
+	// platforms but not others.
+	for _, v := range me.Versions {
+		if !matches(v) {
+			continue
+		}
+		if v.TargetPlatform == target {
+			return v, true
+		}
+		if v.isUniversal() {
+			return v, true
+		}
+	}
+	return selected, false
+}
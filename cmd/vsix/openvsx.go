@@ -0,0 +1,127 @@
+package vsix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// openVSX is an Open VSX registry, e.g. https://open-vsx.org or a self-hosted instance, used by
+// VSCodium and code-server.
+type openVSX struct {
+	baseURL string
+	// token is used to publish extensions.
+	token string
+	// client defaults to http.DefaultClient.
+	client *http.Client
+}
+
+func (o openVSX) httpClient() *http.Client {
+	if o.client == nil {
+		return http.DefaultClient
+	}
+	return o.client
+}
+
+func (openVSX) name() string {
+	return sourceOpenVSX
+}
+
+// openVSXExtension is the subset of the extension metadata returned by the Open VSX API used by
+// impex.
+type openVSXExtension struct {
+	Namespace      string `json:"namespace"`
+	Name           string `json:"name"`
+	Version        string `json:"version"`
+	TargetPlatform string `json:"targetPlatform"`
+	Files          struct {
+		Download string `json:"download"`
+		// Signature is the signature archive, if the registry signs extensions.
+		Signature string `json:"signature"`
+	} `json:"files"`
+	Error string `json:"error"`
+}
+
+func (oe openVSXExtension) isUniversal() bool {
+	return oe.TargetPlatform == "" || oe.TargetPlatform == "universal"
+}
+
+func (o openVSX) resolve(ext extension, targets []string) (assets []asset, err error) {
+	if len(targets) == 0 {
+		oe, found, err := o.get(ext, "")
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: extension not found in %s", ext, o.baseURL)
+		}
+		return []asset{{Version: oe.Version, URL: oe.Files.Download, SignatureURL: fixedURL(oe.Files.Signature)}}, nil
+	}
+	var errs error
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		oe, found, err := o.get(ext, target)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if !found {
+			// Extensions without a build for the target platform may have a universal build.
+			if oe, found, err = o.get(ext, ""); err != nil {
+				errs = errors.Join(errs, err)
+				continue
+			}
+		}
+		if !found || (oe.TargetPlatform != target && !oe.isUniversal()) {
+			errs = errors.Join(errs, fmt.Errorf("%s: no version found for target platform %s", ext.ID(), target))
+			continue
+		}
+		targetPlatform := oe.TargetPlatform
+		if oe.isUniversal() {
+			targetPlatform = ""
+		}
+		if seen[oe.Version+"@"+targetPlatform] {
+			continue
+		}
+		seen[oe.Version+"@"+targetPlatform] = true
+		assets = append(assets, asset{Version: oe.Version, TargetPlatform: targetPlatform, URL: oe.Files.Download, SignatureURL: fixedURL(oe.Files.Signature)})
+	}
+	return assets, errs
+}
+
+// get returns the metadata of the pinned version of the extension, or the latest, for the target
+// platform. If the target platform is empty, the registry's default build is returned.
+func (o openVSX) get(ext extension, target string) (oe openVSXExtension, found bool, err error) {
+	segments := []string{"api", url.PathEscape(ext.Publisher), url.PathEscape(ext.Name)}
+	if target != "" {
+		segments = append(segments, url.PathEscape(target))
+	}
+	if ext.Version != "" {
+		segments = append(segments, url.PathEscape(ext.Version))
+	}
+	from := o.baseURL + "/" + strings.Join(segments, "/")
+	resp, err := o.httpClient().Get(from)
+	if err != nil {
+		return oe, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return oe, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return oe, false, fmt.Errorf("expected status OK for %q, but got %d", from, resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&oe); err != nil {
+		return oe, false, fmt.Errorf("%s: failed to parse Open VSX response: %w", ext.ID(), err)
+	}
+	if oe.Error != "" {
+		return oe, false, fmt.Errorf("%s: %s", ext.ID(), oe.Error)
+	}
+	if oe.Files.Download == "" {
+		return oe, false, fmt.Errorf("%s@%s: no VSIX download found in %q", ext.ID(), oe.Version, from)
+	}
+	return oe, true, nil
+}
@@ -0,0 +1,489 @@
+package vsix
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example-pipeline/impex/internal/list"
+
+	"log/slog"
+)
+
+type ServeArguments struct {
+	// Directory containing the exported extensions, e.g. package/vsix.
+	Directory string
+	// Address to listen on, e.g. :8080
+	Address string
+	Log     *slog.Logger
+}
+
+// Serve indexes the extensions in the directory, and serves them as a read-only gallery that VS
+// Code can use instead of the Marketplace, by setting the extensionsGallery of its product.json.
+func Serve(args ServeArguments) error {
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	log.Info("Indexing extensions", slog.String("directory", args.Directory))
+	s, err := newGalleryServer(args.Directory, log)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Listening", slog.String("address", args.Address), slog.Int("extensions", len(s.extensions)))
+	server := &http.Server{
+		Addr:              args.Address,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+const (
+	assetTypeVSIXManifest = "Microsoft.VisualStudio.Services.VsixManifest"
+	galleryQueryPath      = "/_apis/public/gallery/extensionquery"
+	galleryAssetsPath     = "/assets/"
+)
+
+// galleryServer serves the extension query API and assets of an exported vsix directory.
+type galleryServer struct {
+	log *slog.Logger
+	// extensions maps the lower case extension identifier to the extension.
+	extensions map[string]*servedExtension
+}
+
+type servedExtension struct {
+	Publisher string
+	Name      string
+	// Versions are ordered from newest to oldest.
+	Versions []*servedVersion
+}
+
+type servedVersion struct {
+	Manifest    packageManifest
+	FileName    string
+	LastUpdated time.Time
+}
+
+func newGalleryServer(dir string, log *slog.Logger) (s *galleryServer, err error) {
+	s = &galleryServer{
+		log:        log,
+		extensions: make(map[string]*servedExtension),
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".vsix") {
+			return nil
+		}
+		if err = s.add(p); err != nil {
+			// A single bad extension shouldn't prevent the rest from being served.
+			log.Warn("Skipping extension", slog.String("file", p), slog.Any("error", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index extensions: %w", err)
+	}
+	for _, ext := range s.extensions {
+		sort.Slice(ext.Versions, func(i, j int) bool {
+			a, b := ext.Versions[i].Manifest.Metadata.Identity, ext.Versions[j].Manifest.Metadata.Identity
+			if c := compareVersions(a.Version, b.Version); c != 0 {
+				return c > 0
+			}
+			return a.TargetPlatform < b.TargetPlatform
+		})
+	}
+	return s, nil
+}
+
+func (s *galleryServer) add(fileName string) error {
+	m, err := readPackageManifest(fileName)
+	if err != nil {
+		return err
+	}
+	identity := m.Metadata.Identity
+	if identity.Publisher == "" || identity.ID == "" || identity.Version == "" {
+		return fmt.Errorf("extension.vsixmanifest is missing the publisher, id or version")
+	}
+	if identity.TargetPlatform == "universal" {
+		m.Metadata.Identity.TargetPlatform = ""
+	}
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+
+	key := strings.ToLower(identity.Publisher + "." + identity.ID)
+	ext, ok := s.extensions[key]
+	if !ok {
+		ext = &servedExtension{Publisher: identity.Publisher, Name: identity.ID}
+		s.extensions[key] = ext
+	}
+	if ext.find(identity.Version, m.Metadata.Identity.TargetPlatform) != nil {
+		return fmt.Errorf("duplicate of %s.%s@%s", identity.Publisher, identity.ID, identity.Version)
+	}
+	ext.Versions = append(ext.Versions, &servedVersion{
+		Manifest:    m,
+		FileName:    fileName,
+		LastUpdated: stat.ModTime().UTC(),
+	})
+	return nil
+}
+
+func (ext *servedExtension) find(version, targetPlatform string) *servedVersion {
+	for _, v := range ext.Versions {
+		if v.Manifest.Metadata.Identity.Version == version && v.Manifest.Metadata.Identity.TargetPlatform == targetPlatform {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *galleryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// VS Code for the Web queries the gallery from the browser.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch {
+	case r.URL.Path == galleryQueryPath:
+		if r.Method != http.MethodPost {
+			http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveQuery(w, r)
+	case strings.HasPrefix(r.URL.Path, galleryAssetsPath):
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "read-only gallery", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveAsset(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *galleryServer) serveQuery(w http.ResponseWriter, r *http.Request) {
+	var query extensionQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, "invalid extension query", http.StatusBadRequest)
+		return
+	}
+
+	// The asset URLs are absolute, based on the host that VS Code used to make the request.
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	baseURL := scheme + "://" + r.Host
+
+	var response extensionQueryResponse
+	for _, filter := range query.Filters {
+		matches := s.match(filter)
+		pageSize := filter.PageSize
+		if pageSize <= 0 {
+			pageSize = 50
+		}
+		pageNumber := max(filter.PageNumber, 1)
+		first := min((pageNumber-1)*pageSize, len(matches))
+		last := min(first+pageSize, len(matches))
+
+		result := extensionQueryResult{
+			Extensions: make([]marketplaceExtension, 0, last-first),
+			ResultMetadata: []resultMetadata{
+				{
+					MetadataType:  "ResultCount",
+					MetadataItems: []metadataItem{{Name: "TotalCount", Count: len(matches)}},
+				},
+			},
+		}
+		for _, ext := range matches[first:last] {
+			result.Extensions = append(result.Extensions, ext.gallery(baseURL, query.Flags&flagIncludeLatestVersionOnly != 0))
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.log.Warn("Failed to write extension query response", slog.Any("error", err))
+	}
+}
+
+// match returns the extensions that match the filter, sorted by identifier. Extensions are
+// matched by identifier if the filter includes any, otherwise by search text, tag and category.
+// Other criteria, e.g. the target VS Code version, are ignored.
+func (s *galleryServer) match(filter extensionQueryFilter) (matches []*servedExtension) {
+	ids := make(map[string]bool)
+	var searchText, tags, categories []string
+	for _, c := range filter.Criteria {
+		switch c.FilterType {
+		case filterTypeExtensionName, filterTypeExtensionID:
+			ids[strings.ToLower(c.Value)] = true
+		case filterTypeSearchText:
+			searchText = append(searchText, strings.Fields(strings.ToLower(c.Value))...)
+		case filterTypeTag:
+			tags = append(tags, strings.ToLower(c.Value))
+		case filterTypeCategory:
+			categories = append(categories, strings.ToLower(c.Value))
+		}
+	}
+	keys := make([]string, 0, len(s.extensions))
+	for key := range s.extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ext := s.extensions[key]
+		if len(ids) > 0 {
+			if ids[key] || ids[ext.uuid()] {
+				matches = append(matches, ext)
+			}
+			continue
+		}
+		metadata := ext.Versions[0].Manifest.Metadata
+		text := strings.ToLower(strings.Join([]string{key, metadata.DisplayName, metadata.Description, metadata.Tags}, " "))
+		if containsAll(text, searchText) && intersects(splitLower(metadata.Tags), tags) && intersects(splitLower(metadata.Categories), categories) {
+			matches = append(matches, ext)
+		}
+	}
+	return matches
+}
+
+// gallery returns the extension in the format of the Marketplace API. If latestOnly is true,
+// only the builds of the newest version are included.
+func (ext *servedExtension) gallery(baseURL string, latestOnly bool) marketplaceExtension {
+	latest := ext.Versions[0]
+	metadata := latest.Manifest.Metadata
+	me := marketplaceExtension{
+		ExtensionID:      ext.uuid(),
+		ExtensionName:    ext.Name,
+		DisplayName:      metadata.DisplayName,
+		ShortDescription: metadata.Description,
+		Publisher: marketplacePublisher{
+			PublisherID:   nameUUID("publisher:" + strings.ToLower(ext.Publisher)),
+			PublisherName: ext.Publisher,
+			DisplayName:   ext.Publisher,
+		},
+		Categories:  list.Split(metadata.Categories),
+		Tags:        list.Split(metadata.Tags),
+		Flags:       metadata.GalleryFlags,
+		LastUpdated: latest.LastUpdated.Format(time.RFC3339),
+	}
+	for _, v := range ext.Versions {
+		identity := v.Manifest.Metadata.Identity
+		if latestOnly && identity.Version != metadata.Identity.Version {
+			continue
+		}
+		targetPlatform := identity.TargetPlatform
+		if targetPlatform == "" {
+			targetPlatform = "universal"
+		}
+		assetURI := baseURL + galleryAssetsPath + strings.Join([]string{
+			url.PathEscape(ext.Publisher),
+			url.PathEscape(ext.Name),
+			url.PathEscape(identity.Version),
+			url.PathEscape(targetPlatform),
+		}, "/")
+		mv := marketplaceVersion{
+			Version:        identity.Version,
+			TargetPlatform: identity.TargetPlatform,
+			LastUpdated:    v.LastUpdated.Format(time.RFC3339),
+			AssetURI:       assetURI,
+			FallbackURI:    assetURI,
+			Properties:     []marketplaceProperty{},
+		}
+		assetTypes := []string{assetTypeVSIXPackage, assetTypeVSIXManifest}
+		for _, a := range v.Manifest.Assets {
+			assetTypes = append(assetTypes, a.Type)
+		}
+		for _, assetType := range assetTypes {
+			mv.Files = append(mv.Files, marketplaceFile{AssetType: assetType, Source: assetURI + "/" + url.PathEscape(assetType)})
+		}
+		for _, p := range v.Manifest.Metadata.Properties {
+			mv.Properties = append(mv.Properties, marketplaceProperty{Key: p.ID, Value: p.Value})
+		}
+		me.Versions = append(me.Versions, mv)
+	}
+	return me
+}
+
+// uuid returns a stable identifier for the extension. The Marketplace identifiers aren't in
+// the VSIX, so a name based identifier is used instead.
+func (ext *servedExtension) uuid() string {
+	return nameUUID("extension:" + strings.ToLower(ext.Publisher+"."+ext.Name))
+}
+
+// nameUUID returns a version 5 (SHA-1 name based) UUID for the name.
+func nameUUID(name string) string {
+	sum := sha1.Sum([]byte("impex:" + name))
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(sum[:16])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// serveAsset serves /assets/<publisher>/<name>/<version>/<target platform>/<asset type>. The VSIX
+// is served from the file, and other assets, e.g. the README, from inside the VSIX.
+func (s *galleryServer) serveAsset(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), galleryAssetsPath), "/")
+	if len(segments) != 5 {
+		http.NotFound(w, r)
+		return
+	}
+	for i, segment := range segments {
+		var err error
+		if segments[i], err = url.PathUnescape(segment); err != nil {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+	}
+	publisher, name, version, targetPlatform, assetType := segments[0], segments[1], segments[2], segments[3], segments[4]
+	if targetPlatform == "universal" {
+		targetPlatform = ""
+	}
+	ext, ok := s.extensions[strings.ToLower(publisher+"."+name)]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	v := ext.find(version, targetPlatform)
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if assetType == assetTypeVSIXPackage {
+		f, err := os.Open(v.FileName)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "failed to open extension", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/vsix")
+		http.ServeContent(w, r, path.Base(v.FileName), v.LastUpdated, f)
+		return
+	}
+
+	assetPath := "extension.vsixmanifest"
+	if assetType != assetTypeVSIXManifest {
+		assetPath = ""
+		for _, a := range v.Manifest.Assets {
+			if a.Type == assetType {
+				assetPath = a.Path
+				break
+			}
+		}
+		if assetPath == "" {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	zr, err := zip.OpenReader(v.FileName)
+	if err != nil {
+		http.Error(w, "failed to open extension", http.StatusInternalServerError)
+		return
+	}
+	defer zr.Close()
+	f, err := zr.Open(assetPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	contentType := mime.TypeByExtension(path.Ext(assetPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if stat, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		s.log.Warn("Failed to write asset", slog.String("file", v.FileName), slog.String("asset", assetType), slog.Any("error", err))
+	}
+}
+
+// compareVersions compares dot separated versions, e.g. 1.10.0 and 1.9.2, numerically where
+// possible.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func splitLower(s string) []string {
+	return list.Split(strings.ToLower(s))
+}
+
+// containsAll returns true if the text contains every term.
+func containsAll(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects returns true if any of the values are wanted, or nothing is wanted.
+func intersects(values, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		for _, v := range values {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,385 @@
+package vsix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"log/slog"
+)
+
+const assetTypeDetails = "Microsoft.VisualStudio.Services.Content.Details"
+
+// writeGalleryVSIX writes a VSIX with a README asset, and the display name, tags and categories
+// searched by the gallery, and returns its file name.
+func writeGalleryVSIX(t *testing.T, dir, publisher, name, version, targetPlatform, tags, categories string) string {
+	t.Helper()
+	fileName := filepath.Join(dir, vsixFileName(publisher, name, version, targetPlatform))
+	writeZip(t, fileName, map[string]string{
+		"extension/package.json": fmt.Sprintf(`{"publisher":%q,"name":%q,"version":%q}`, publisher, name, version),
+		"extension/README.md":    "# " + name + " " + version,
+		"extension.vsixmanifest": fmt.Sprintf(`<PackageManifest>
+  <Metadata>
+    <Identity Id=%q Version=%q Publisher=%q TargetPlatform=%q/>
+    <DisplayName>%s</DisplayName>
+    <Description>The %s extension</Description>
+    <Tags>%s</Tags>
+    <Categories>%s</Categories>
+  </Metadata>
+  <Assets>
+    <Asset Type=%q Path="extension/README.md"/>
+  </Assets>
+</PackageManifest>`, name, version, publisher, targetPlatform, name, name, tags, categories, assetTypeDetails),
+	})
+	return fileName
+}
+
+func newTestGalleryServer(t *testing.T) (server *httptest.Server, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	files = map[string]string{
+		"golang.go@0.41.0":                    writeGalleryVSIX(t, dir, "golang", "go", "0.41.0", "", "go,debuggers", "Programming Languages"),
+		"golang.go@0.9.0":                     writeGalleryVSIX(t, dir, "golang", "go", "0.9.0", "", "go", "Programming Languages"),
+		"ms-python.python@2024.2.1 linux-x64": writeGalleryVSIX(t, dir, "ms-python", "python", "2024.2.1", "linux-x64", "python", "Programming Languages,Debuggers"),
+		"ms-python.python@2024.2.1 win32-x64": writeGalleryVSIX(t, dir, "ms-python", "python", "2024.2.1", "win32-x64", "python", "Programming Languages,Debuggers"),
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid.vsix"), []byte("not a zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newGalleryServer(dir, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server = httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server, files
+}
+
+func TestGalleryServerQuery(t *testing.T) {
+	server, _ := newTestGalleryServer(t)
+	criteria := func(filterType int, value string) extensionQueryFilter {
+		return extensionQueryFilter{Criteria: []extensionQueryCriterion{{FilterType: filterType, Value: value}}}
+	}
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+		// expected lists the extensions of each result, with the versions of each extension, e.g.
+		// golang.go@0.41.0. Platform builds are suffixed with the target platform.
+		expected [][]string
+		// expectedCounts are the total count of each result.
+		expectedCounts []int
+	}{
+		{
+			name: "by name, case insensitive",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeExtensionName, "GoLang.Go")},
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"golang.go@0.41.0 golang.go@0.9.0"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "by extension ID",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeExtensionID, nameUUID("extension:ms-python.python"))},
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"ms-python.python@2024.2.1/linux-x64 ms-python.python@2024.2.1/win32-x64"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "latest version only",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeExtensionName, "golang.go")},
+				Flags:   flagIncludeVersions | flagIncludeFiles | flagIncludeLatestVersionOnly,
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"golang.go@0.41.0"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "search text",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeSearchText, "Python extension")},
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"ms-python.python@2024.2.1/linux-x64 ms-python.python@2024.2.1/win32-x64"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "tag",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeTag, "Debuggers")},
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"golang.go@0.41.0 golang.go@0.9.0"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "category",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{criteria(filterTypeCategory, "debuggers")},
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"ms-python.python@2024.2.1/linux-x64 ms-python.python@2024.2.1/win32-x64"}},
+			expectedCounts: []int{1},
+		},
+		{
+			name: "pages and multiple filters",
+			body: queryBody(t, extensionQuery{
+				Filters: []extensionQueryFilter{
+					{PageNumber: 2, PageSize: 1},
+					{PageNumber: 3, PageSize: 1},
+					criteria(filterTypeExtensionName, "missing.extension"),
+				},
+				Flags: flagIncludeLatestVersionOnly,
+			}),
+			expectedStatus: http.StatusOK,
+			expected:       [][]string{{"ms-python.python@2024.2.1/linux-x64 ms-python.python@2024.2.1/win32-x64"}, {}, {}},
+			expectedCounts: []int{2, 2, 0},
+		},
+		{
+			name:           "invalid query",
+			body:           "{",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "GET",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, server.URL+galleryQueryPath, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, resp.StatusCode, body)
+			}
+			if test.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response extensionQueryResponse
+			if err = json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			var actual [][]string
+			var actualCounts []int
+			for _, result := range response.Results {
+				extensions := []string{}
+				for _, ext := range result.Extensions {
+					var versions []string
+					for _, v := range ext.Versions {
+						version := ext.Publisher.PublisherName + "." + ext.ExtensionName + "@" + v.Version
+						if v.TargetPlatform != "" {
+							version += "/" + v.TargetPlatform
+						}
+						versions = append(versions, version)
+					}
+					extensions = append(extensions, strings.Join(versions, " "))
+				}
+				actual = append(actual, extensions)
+				actualCounts = append(actualCounts, result.ResultMetadata[0].MetadataItems[0].Count)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+			if !reflect.DeepEqual(actualCounts, test.expectedCounts) {
+				t.Errorf("expected total counts %v, got %v", test.expectedCounts, actualCounts)
+			}
+		})
+	}
+}
+
+func queryBody(t *testing.T, query extensionQuery) string {
+	t.Helper()
+	data, err := json.Marshal(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGalleryServerAssets(t *testing.T) {
+	server, files := newTestGalleryServer(t)
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+		// expectedFile is the VSIX that is served, or expectedBody the asset.
+		expectedFile string
+		expectedBody string
+	}{
+		{
+			name:           "VSIX",
+			path:           "/assets/golang/go/0.9.0/universal/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusOK,
+			expectedFile:   files["golang.go@0.9.0"],
+		},
+		{
+			name:           "platform VSIX",
+			path:           "/assets/ms-python/python/2024.2.1/win32-x64/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusOK,
+			expectedFile:   files["ms-python.python@2024.2.1 win32-x64"],
+		},
+		{
+			name:           "asset from the VSIX",
+			path:           "/assets/golang/go/0.41.0/universal/" + assetTypeDetails,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "# go 0.41.0",
+		},
+		{
+			name:           "case insensitive identifier",
+			path:           "/assets/GoLang/Go/0.41.0/universal/" + assetTypeDetails,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "# go 0.41.0",
+		},
+		{
+			name:           "HEAD",
+			method:         http.MethodHead,
+			path:           "/assets/golang/go/0.41.0/universal/" + assetTypeDetails,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "package manifest",
+			path:           "/assets/golang/go/0.41.0/universal/" + assetTypeVSIXManifest,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing asset type",
+			path:           "/assets/golang/go/0.41.0/universal/Microsoft.VisualStudio.Services.Icons.Default",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "missing version",
+			path:           "/assets/golang/go/1.0.0/universal/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "missing target platform",
+			path:           "/assets/ms-python/python/2024.2.1/universal/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "missing extension",
+			path:           "/assets/golang/missing/0.41.0/universal/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "too few segments",
+			path:           "/assets/golang/go/0.41.0/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "POST",
+			method:         http.MethodPost,
+			path:           "/assets/golang/go/0.41.0/universal/" + assetTypeVSIXPackage,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, resp.StatusCode, body)
+			}
+			if test.expectedFile != "" {
+				expected, err := os.ReadFile(test.expectedFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(body, expected) {
+					t.Errorf("expected the content of %s", test.expectedFile)
+				}
+			}
+			if test.expectedBody != "" && string(body) != test.expectedBody {
+				t.Errorf("expected %q, got %q", test.expectedBody, body)
+			}
+		})
+	}
+}
+
+// TestGalleryServerAssetURIs checks that the files listed by the query are served by the asset
+// route.
+func TestGalleryServerAssetURIs(t *testing.T) {
+	server, _ := newTestGalleryServer(t)
+	body := queryBody(t, extensionQuery{
+		Filters: []extensionQueryFilter{{}},
+		Flags:   flagIncludeFiles | flagIncludeAssetURI,
+	})
+	resp, err := http.Post(server.URL+galleryQueryPath, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response extensionQueryResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	var sources int
+	for _, ext := range response.Results[0].Extensions {
+		for _, v := range ext.Versions {
+			if !strings.HasPrefix(v.AssetURI, server.URL+galleryAssetsPath) {
+				t.Errorf("expected the asset URI to be served by %s, got %s", server.URL, v.AssetURI)
+			}
+			for _, f := range v.Files {
+				resp, err := http.Get(f.Source)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("expected status %d for %s, got %d", http.StatusOK, f.Source, resp.StatusCode)
+				}
+				sources++
+			}
+		}
+	}
+	// Each of the 4 builds has a VSIX, package manifest and README.
+	if expected := 12; sources != expected {
+		t.Errorf("expected %d files, got %d", expected, sources)
+	}
+}
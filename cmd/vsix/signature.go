@@ -0,0 +1,182 @@
+package vsix
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"unicode/utf8"
+)
+
+// signatureManifestFileName is the file in the signature archive that lists the digest of the
+// VSIX, and of every file in it. The archive also contains .signature.p7s, the publisher's
+// signature of the manifest.
+const signatureManifestFileName = ".signature.manifest"
+
+// signatureManifest is the .signature.manifest of a signature archive.
+type signatureManifest struct {
+	Package signatureEntry `json:"package"`
+	// Entries are keyed by the base64 encoded path of the file in the VSIX.
+	Entries map[string]signatureEntry `json:"entries"`
+}
+
+type signatureEntry struct {
+	Size int64 `json:"size"`
+	// Digests map the hash algorithm, e.g. sha256, to the base64 encoded digest.
+	Digests map[string]string `json:"digests"`
+}
+
+// readSignatureManifest reads the manifest from a signature archive, which is a zip file.
+func readSignatureManifest(fileName string) (m signatureManifest, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open signature archive: %w", fileName, err)
+	}
+	defer zr.Close()
+	f, err := zr.Open(signatureManifestFileName)
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open %s: %w", fileName, signatureManifestFileName, err)
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: failed to parse %s: %w", fileName, signatureManifestFileName, err)
+	}
+	return m, nil
+}
+
+// verifySignature checks the VSIX, and every file in it, against the digests of the signature
+// archive. Files that are missing, changed, or not listed by the signature are reported.
+//
+// The publisher's signature of the manifest is not checked, since that requires the signing
+// certificate chain.
+func verifySignature(vsixFileName, signatureFileName string) error {
+	m, err := readSignatureManifest(signatureFileName)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	if _, ok := m.Package.Digests["sha256"]; ok {
+		sum, size, err := hashFile(vsixFileName)
+		if err != nil {
+			return err
+		}
+		if err = m.Package.verify(sum, size); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", vsixFileName, err))
+		}
+	}
+
+	zr, err := zip.OpenReader(vsixFileName)
+	if err != nil {
+		return fmt.Errorf("%s: failed to open VSIX: %w", vsixFileName, err)
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files[f.Name] = f
+		}
+	}
+
+	signed := make(map[string]bool, len(m.Entries))
+	for key, entry := range m.Entries {
+		name := decodeEntryName(key, files)
+		signed[name] = true
+		f, ok := files[name]
+		if !ok {
+			errs = errors.Join(errs, fmt.Errorf("%s: %s is signed, but missing", vsixFileName, name))
+			continue
+		}
+		sum, size, err := hashZipFile(f)
+		if err != nil {
+			return fmt.Errorf("%s: failed to read %s: %w", vsixFileName, name, err)
+		}
+		if err = entry.verify(sum, size); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %s: %w", vsixFileName, name, err))
+		}
+	}
+	var unsigned []string
+	for name := range files {
+		if !signed[name] {
+			unsigned = append(unsigned, name)
+		}
+	}
+	sort.Strings(unsigned)
+	for _, name := range unsigned {
+		errs = errors.Join(errs, fmt.Errorf("%s: %s is not signed", vsixFileName, name))
+	}
+	return errs
+}
+
+// verify checks the sha256 digest, and size, of a file.
+func (e signatureEntry) verify(sum []byte, size int64) error {
+	expected, ok := e.Digests["sha256"]
+	if !ok {
+		return fmt.Errorf("no sha256 digest in the signature")
+	}
+	if !digestMatches(expected, sum) {
+		return fmt.Errorf("expected sha256 %s from the signature, but got %s", expected, base64.StdEncoding.EncodeToString(sum))
+	}
+	if e.Size != 0 && e.Size != size {
+		return fmt.Errorf("expected size %d from the signature, but got %d", e.Size, size)
+	}
+	return nil
+}
+
+// digestMatches compares a base64, or hex, encoded digest.
+func digestMatches(expected string, sum []byte) bool {
+	for _, decode := range []func(string) ([]byte, error){base64.StdEncoding.DecodeString, base64.RawStdEncoding.DecodeString, hex.DecodeString} {
+		if digest, err := decode(expected); err == nil && bytes.Equal(digest, sum) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeEntryName returns the path of a signature manifest entry, whose key is the base64
+// encoded path.
+func decodeEntryName(key string, files map[string]*zip.File) string {
+	if _, ok := files[key]; ok {
+		return key
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if name, err := enc.DecodeString(key); err == nil && len(name) > 0 && utf8.Valid(name) {
+			return string(name)
+		}
+	}
+	return key
+}
+
+// hashFile returns the sha256 and size of a file.
+func hashFile(fileName string) (sum []byte, size int64, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if size, err = io.Copy(hash, f); err != nil {
+		return nil, 0, err
+	}
+	return hash.Sum(nil), size, nil
+}
+
+func hashZipFile(f *zip.File) (sum []byte, size int64, err error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	if size, err = io.Copy(hash, r); err != nil {
+		return nil, 0, err
+	}
+	return hash.Sum(nil), size, nil
+}
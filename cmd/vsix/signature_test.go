@@ -0,0 +1,149 @@
+package vsix
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip writes a zip file containing the files, keyed by path.
+func writeZip(t *testing.T, fileName string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for path, content := range files {
+		w, err := zw.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSignatureArchive writes a signature archive of the VSIX, in the format published by the
+// Marketplace, and returns its file name.
+func writeSignatureArchive(t *testing.T, vsixFileName string) string {
+	t.Helper()
+	digest := func(data []byte) signatureEntry {
+		sum := sha256.Sum256(data)
+		return signatureEntry{
+			Size:    int64(len(data)),
+			Digests: map[string]string{"sha256": base64.StdEncoding.EncodeToString(sum[:])},
+		}
+	}
+	data, err := os.ReadFile(vsixFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := signatureManifest{Package: digest(data), Entries: make(map[string]signatureEntry)}
+	zr, err := zip.OpenReader(vsixFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Entries[base64.StdEncoding.EncodeToString([]byte(f.Name))] = digest(data)
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := strings.TrimSuffix(vsixFileName, ".vsix") + ".sigzip"
+	writeZip(t, fileName, map[string]string{
+		signatureManifestFileName: string(manifest),
+		".signature.p7s":          "not checked",
+	})
+	return fileName
+}
+
+func TestVerifySignature(t *testing.T) {
+	signed := map[string]string{
+		"extension/package.json": `{"publisher":"pub","name":"ext","version":"1.0.0"}`,
+		"extension/extension.js": "exports.activate = () => {}",
+	}
+	tests := []struct {
+		name string
+		// files replace the content of the signed VSIX, if set.
+		files map[string]string
+		// expectedErrs are the problems that are reported, if any.
+		expectedErrs []string
+	}{
+		{
+			name: "matching",
+		},
+		{
+			name: "changed file",
+			files: map[string]string{
+				"extension/package.json": signed["extension/package.json"],
+				"extension/extension.js": "exports.activate = () => { steal() }",
+			},
+			expectedErrs: []string{"pub.ext-1.0.0.vsix: expected sha256", "extension/extension.js: expected sha256"},
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"extension/package.json": signed["extension/package.json"],
+			},
+			expectedErrs: []string{"extension/extension.js is signed, but missing"},
+		},
+		{
+			name: "unsigned file",
+			files: map[string]string{
+				"extension/package.json": signed["extension/package.json"],
+				"extension/extension.js": signed["extension/extension.js"],
+				"extension/added.js":     "steal()",
+			},
+			expectedErrs: []string{"extension/added.js is not signed"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vsixFileName := filepath.Join(t.TempDir(), "pub.ext-1.0.0.vsix")
+			writeZip(t, vsixFileName, signed)
+			signatureFileName := writeSignatureArchive(t, vsixFileName)
+			if test.files != nil {
+				writeZip(t, vsixFileName, test.files)
+			}
+
+			err := verifySignature(vsixFileName, signatureFileName)
+			if len(test.expectedErrs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, expected := range test.expectedErrs {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected error to contain %q, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package vsix
+
+// Names of the sources extensions can be downloaded from.
+const (
+	sourceMarketplace = "marketplace"
+	sourceOpenVSX     = "open-vsx"
+)
+
+var sourceNames = []string{sourceMarketplace, sourceOpenVSX}
+
+func isSourceName(name string) bool {
+	for _, n := range sourceNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// source is a registry that extensions are downloaded from, e.g. the Visual Studio Marketplace.
+type source interface {
+	name() string
+	// resolve returns the VSIX to download for each target platform, or the default build if
+	// there are no targets. Universal builds are returned once, since they run on every
+	// platform. The pinned version of the extension is used, or the latest release. Some assets
+	// may be returned even if others can't be resolved.
+	resolve(ext extension, targets []string) (assets []asset, err error)
+}
+
+// asset is a VSIX to download.
+type asset struct {
+	// Version is empty if it isn't known until the VSIX is downloaded.
+	Version string
+	// TargetPlatform is empty for the default build, e.g. a universal extension.
+	TargetPlatform string
+	URL            string
+	// SignatureURL returns the URL of the signature archive for the downloaded version, or is nil
+	// if the source doesn't sign extensions. The signature must be for the same version as the
+	// VSIX, which isn't known until it's downloaded if Version is empty.
+	SignatureURL func(version string) string
+}
+
+// fixedURL returns a SignatureURL for a signature archive whose URL doesn't depend on the
+// version, or nil if the URL is empty.
+func fixedURL(u string) func(version string) string {
+	if u == "" {
+		return nil
+	}
+	return func(string) string {
+		return u
+	}
+}
@@ -0,0 +1,182 @@
+package vsix
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"log/slog"
+)
+
+type VerifyArguments struct {
+	// LockFileName is the lock file written by the export, e.g. package/vsix/vsix-lock.json.
+	// The extensions are read from the same directory, so the directory can be moved.
+	LockFileName string
+	Log          *slog.Logger
+}
+
+// Verify checks the exported extensions, without network access. Each VSIX and signature archive
+// must match the sha256 recorded in the lock file, as must each file in the VSIX, and each
+// signed VSIX must match the digests of its signature. Extensions in the directory that aren't in
+// the lock file are reported too.
+func Verify(args VerifyArguments) error {
+	start := time.Now()
+
+	// Create log.
+	log := args.Log
+	if log == nil {
+		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	log.Info("Reading lock file", slog.String("file", args.LockFileName))
+	if _, err := os.Stat(args.LockFileName); err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+	lockFile, err := readLockFile(args.LockFileName)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(args.LockFileName)
+
+	var errs error
+	var verified, unsigned int
+	locked := make(map[string]bool)
+	for _, le := range lockFile.Extensions {
+		locked[path.Base(le.FileName)] = true
+		if le.SignatureFileName != "" {
+			locked[path.Base(le.SignatureFileName)] = true
+		}
+		signed, err := verifyLocked(dir, le)
+		if err != nil {
+			log.Error("Failed to verify", slog.String("name", le.String()), slog.Any("error", err))
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if !signed {
+			log.Warn("Not signed, verified the lock file digests only", slog.String("name", le.String()))
+			unsigned++
+		}
+		verified++
+	}
+
+	// Files that aren't in the lock file would be served and imported without being verified.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list extensions: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		isExtension := strings.HasSuffix(name, ".vsix") || strings.HasSuffix(name, ".sigzip")
+		if entry.IsDir() || locked[name] || !isExtension {
+			continue
+		}
+		log.Error("Not in the lock file", slog.String("file", name))
+		err = fmt.Errorf("%s: not in the lock file %q", filepath.Join(dir, name), args.LockFileName)
+		errs = errors.Join(errs, err)
+	}
+
+	log.Info("Complete",
+		slog.Int("total", len(lockFile.Extensions)),
+		slog.Int("verified", verified),
+		slog.Int("unsigned", unsigned),
+		slog.String("duration", time.Now().Sub(start).String()))
+	return errs
+}
+
+// verifyLocked checks the files of an extension against the lock file, and the signature. It
+// returns false if the extension isn't signed.
+func verifyLocked(dir string, le LockedExtension) (signed bool, err error) {
+	vsixFileName := filepath.Join(dir, path.Base(le.FileName))
+	if err = verifySHA256(vsixFileName, le.SHA256); err != nil {
+		return false, err
+	}
+	if err = verifyFiles(vsixFileName, le.Files); err != nil {
+		return false, err
+	}
+	if le.SignatureFileName == "" {
+		return false, nil
+	}
+	signatureFileName := filepath.Join(dir, path.Base(le.SignatureFileName))
+	if err = verifySHA256(signatureFileName, le.SignatureSHA256); err != nil {
+		return true, err
+	}
+	return true, verifySignature(vsixFileName, signatureFileName)
+}
+
+func verifySHA256(fileName, expected string) error {
+	sum, _, err := hashFile(fileName)
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(sum); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("%s: expected sha256 %s from the lock file, but got %s", fileName, expected, actual)
+	}
+	return nil
+}
+
+// hashVSIXFiles returns the hex encoded sha256 of each file in the VSIX, keyed by path.
+func hashVSIXFiles(fileName string) (files map[string]string, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to open VSIX: %w", fileName, err)
+	}
+	defer zr.Close()
+	files = make(map[string]string, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		sum, _, err := hashZipFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to read %s: %w", fileName, f.Name, err)
+		}
+		files[f.Name] = hex.EncodeToString(sum)
+	}
+	return files, nil
+}
+
+// verifyFiles checks each file in the VSIX against the digests in the lock file. Files that are
+// missing, changed, or not in the lock file are reported.
+func verifyFiles(fileName string, expected map[string]string) error {
+	if len(expected) == 0 {
+		return fmt.Errorf("%s: no file digests in the lock file, export the extension again to "+
+			"record them", fileName)
+	}
+	actual, err := hashVSIXFiles(fileName)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs error
+	for _, name := range names {
+		e, inLock := expected[name]
+		a, inVSIX := actual[name]
+		switch {
+		case !inVSIX:
+			errs = errors.Join(errs, fmt.Errorf("%s: %s is in the lock file, but missing", fileName, name))
+		case !inLock:
+			errs = errors.Join(errs, fmt.Errorf("%s: %s is not in the lock file", fileName, name))
+		case !strings.EqualFold(e, a):
+			err = fmt.Errorf("%s: %s: expected sha256 %s from the lock file, but got %s",
+				fileName, name, e, a)
+			errs = errors.Join(errs, err)
+		}
+	}
+	return errs
+}
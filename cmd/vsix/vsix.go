@@ -1,22 +1,48 @@
 package vsix
 
 import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/example-pipeline/impex/internal/list"
+
 	"log/slog"
 )
 
 type Arguments struct {
+	// FileName of the list of extensions, one per line, e.g. golang.go or golang.go@0.41.0
 	FileName string
-	Log      *slog.Logger
+	// WorkspaceFiles are .vscode/extensions.json or devcontainer.json files, whose extensions
+	// are added to the list.
+	WorkspaceFiles []string
+	// ScanDirectories are searched for .vscode/extensions.json and devcontainer.json files.
+	ScanDirectories []string
+	// OutputLockFileName is where the exact version and sha256 of each downloaded extension is
+	// written. Defaults to package/vsix/vsix-lock.json
+	OutputLockFileName string
+	// Source is the default source to download extensions from, either marketplace or
+	// open-vsx. Lines of the list can override it with a prefix, e.g. open-vsx:golang.go
+	// Defaults to marketplace.
+	Source string
+	// OpenVSXURL is the base URL of the Open VSX registry. Defaults to https://open-vsx.org
+	OpenVSXURL string
+	// Targets are the platforms to download platform-specific builds for, e.g. linux-x64. If
+	// empty, the build served by the Marketplace by default is downloaded.
+	Targets []string
+	Log     *slog.Logger
 }
 
 func Run(args Arguments) error {
@@ -27,12 +53,69 @@ func Run(args Arguments) error {
 	if log == nil {
 		log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	}
+	outputLockFileName := args.OutputLockFileName
+	if outputLockFileName == "" {
+		outputLockFileName = "package/vsix/vsix-lock.json"
+	}
+
+	defaultSource := args.Source
+	if defaultSource == "" {
+		defaultSource = sourceMarketplace
+	}
+	if !isSourceName(defaultSource) {
+		return fmt.Errorf("invalid source %q, expected one of %s", defaultSource, strings.Join(sourceNames, ", "))
+	}
+	openVSXURL := args.OpenVSXURL
+	if openVSXURL == "" {
+		openVSXURL = "https://open-vsx.org"
+	}
+	sources := map[string]source{
+		sourceMarketplace: marketplace{},
+		sourceOpenVSX:     openVSX{baseURL: strings.TrimSuffix(openVSXURL, "/")},
+	}
+	for _, target := range args.Targets {
+		if !isTargetPlatform(target) {
+			return fmt.Errorf("invalid target platform %q, expected one of %s", target, strings.Join(targetPlatforms, ", "))
+		}
+	}
+
+	// Parse the input files. The list is read first, so that its pinned versions take
+	// precedence.
+	var entries []list.Entry
+	var err error
+	if args.FileName != "" {
+		log.Info("Parsing input file", slog.String("file", args.FileName))
+		if entries, err = list.Read(args.FileName); err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+	}
+	workspaceFiles := args.WorkspaceFiles
+	for _, dir := range args.ScanDirectories {
+		log.Info("Scanning for workspace files", slog.String("directory", dir))
+		found, err := findWorkspaceFiles(dir)
+		if err != nil {
+			return err
+		}
+		workspaceFiles = append(workspaceFiles, found...)
+	}
+	parsed := make(map[string]bool)
+	for _, fileName := range workspaceFiles {
+		if parsed[filepath.Clean(fileName)] {
+			continue
+		}
+		parsed[filepath.Clean(fileName)] = true
+		log.Info("Parsing workspace file", slog.String("file", fileName))
+		workspaceEntries, err := readWorkspaceExtensions(fileName)
+		if err != nil {
+			return fmt.Errorf("failed to read workspace file: %w", err)
+		}
+		entries = append(entries, workspaceEntries...)
+	}
 
-	// Parse the input file.
-	log.Info("Parsing input file")
-	data, err := os.ReadFile(args.FileName)
+	// Read the previous lock file, to check that downloaded versions haven't changed.
+	previous, err := readLockFile(outputLockFileName)
 	if err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
+		return err
 	}
 
 	// Create output directory if required.
@@ -40,53 +123,467 @@ func Run(args Arguments) error {
 		return err
 	}
 
-	// Download vsix files.
-	downloads := strings.Split(string(data), "\n")
+	// Parse the list, so that pinned versions take precedence over dependencies. Each extension
+	// is exported from a single source, since the file names don't include the source, so queued
+	// maps the lower case extension identifier to its source.
 	var errs error
+	var queue []extension
+	queued := make(map[string]string)
+	for _, entry := range entries {
+		ext, err := parseExtension(entry.Value)
+		if err != nil {
+			errs = errors.Join(errs, entry.Wrap(err))
+			continue
+		}
+		explicitSource := ext.Source != ""
+		if !explicitSource {
+			ext.Source = defaultSource
+		}
+		if src, ok := queued[strings.ToLower(ext.ID())]; ok {
+			if explicitSource && src != ext.Source {
+				errs = errors.Join(errs, entry.Errorf("%s is already listed from %s", ext, src))
+			}
+			continue
+		}
+		queued[strings.ToLower(ext.ID())] = ext.Source
+		queue = append(queue, ext)
+	}
+
+	// Download vsix files. Extension packs and dependencies are added to the queue as each
+	// extension is downloaded, until every dependency has been downloaded.
 	var downloadsComplete int
-	for _, pkg := range downloads {
-		if len(pkg) == 0 {
+	var lockFile LockFile
+	for i := 0; i < len(queue); i++ {
+		ext := queue[i]
+		log.Info("Downloading", slog.String("name", ext.String()), slog.Int("index", i+1), slog.Int("total", len(queue)))
+		downloaded, kept, err := downloadAll(sources[ext.Source], ext, args.Targets, previous)
+		if err != nil {
+			errs = errors.Join(errs, err)
+		}
+		// Keep the approved checksums of changed downloads, so that the change isn't accepted by
+		// the next export.
+		lockFile.Extensions = append(lockFile.Extensions, kept...)
+		lockFile.Extensions = append(lockFile.Extensions, downloaded...)
+		downloadsComplete += len(downloaded)
+		if len(downloaded) == 0 {
 			continue
 		}
-		log.Info("Downloading", slog.String("name", pkg), slog.Int("total", len(downloads)))
-		err := download(pkg)
+
+		// Queue the extension's dependencies. Every target platform has the same manifest.
+		m, err := readManifest(downloaded[0].FileName)
 		if err != nil {
-			errs = errors.Join(err)
+			errs = errors.Join(errs, err)
+			continue
+		}
+		for _, dep := range m.dependencies() {
+			if src, ok := queued[strings.ToLower(dep.ID)]; ok {
+				if src != ext.Source && !isBuiltIn(dep.ID) {
+					log.Info("Dependency is already queued from another source", slog.String("name", dep.ID),
+						slog.String("requiredBy", ext.String()), slog.String("source", src))
+				}
+				continue
+			}
+			queued[strings.ToLower(dep.ID)] = ext.Source
+			if isBuiltIn(dep.ID) {
+				log.Debug("Skipping built-in extension", slog.String("name", dep.ID), slog.String("requiredBy", ext.ID()))
+				continue
+			}
+			depExt, err := parseExtension(dep.ID)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("%s: invalid %s entry: %w", ext.ID(), dep.Reason, err))
+				continue
+			}
+			// Dependencies are downloaded from the same source as the extension that requires them.
+			depExt.Source = ext.Source
+			log.Info("Adding dependency", slog.String("name", dep.ID), slog.String("requiredBy", ext.ID()), slog.String("reason", dep.Reason))
+			queue = append(queue, depExt)
 		}
-		downloadsComplete++
 	}
 
-	log.Info("Complete", slog.Int("total", len(downloads)), slog.String("duration", time.Now().Sub(start).String()))
+	// Record what was downloaded. Extensions exported by previous runs are still in package/vsix,
+	// so they're kept in the lock file too.
+	log.Info("Writing lock file", slog.String("file", outputLockFileName))
+	if err = writeLockFile(outputLockFileName, lockFile.merge(previous)); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	log.Info("Complete", slog.Int("total", len(queue)), slog.Int("downloaded", downloadsComplete), slog.String("duration", time.Now().Sub(start).String()))
 	return errs
 }
 
-func download(name string) (err error) {
-	// Download the file.
-	parts := strings.SplitN(name, ".", 2)
-	publisher, name := parts[0], parts[1]
-	from := fmt.Sprintf("https://%s.gallery.vsassets.io/_apis/public/gallery/publisher/%s/extension/%s/latest/assetbyname/Microsoft.VisualStudio.Services.VSIXPackage",
-		url.PathEscape(publisher),
-		url.PathEscape(publisher),
-		url.PathEscape(name))
+// extension is an entry in the list of extensions, e.g. golang.go, golang.go@0.41.0 or
+// open-vsx:golang.go@0.41.0
+type extension struct {
+	// Source is the name of the source to download from, or empty for the default source.
+	Source    string
+	Publisher string
+	Name      string
+	// Version is empty for the latest version.
+	Version string
+}
+
+func parseExtension(s string) (ext extension, err error) {
+	s = strings.TrimSpace(s)
+	src, id, hasSource := strings.Cut(s, ":")
+	if !hasSource {
+		src, id = "", s
+	} else if !isSourceName(src) {
+		return ext, fmt.Errorf("invalid extension %q, unknown source %q, expected one of %s", s, src, strings.Join(sourceNames, ", "))
+	}
+	id, version, hasVersion := strings.Cut(id, "@")
+	publisher, name, ok := strings.Cut(id, ".")
+	if !ok || !isValidIdentifier(publisher) || !isValidIdentifier(name) {
+		return ext, fmt.Errorf("invalid extension %q, expected publisher.name or publisher.name@version", s)
+	}
+	if hasVersion && !versionPattern.MatchString(version) {
+		return ext, fmt.Errorf("invalid extension %q, expected a version such as 1.2.3 after the @", s)
+	}
+	return extension{Source: src, Publisher: publisher, Name: name, Version: version}, nil
+}
+
+// identifierPattern matches publisher and extension names. Names are letters, digits, hyphens
+// and underscores, and can't contain dots, since the dot separates the publisher and the name.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// versionPattern matches the semantic versions used by extensions, e.g. 1.2.3 or 1.2.3-beta.1
+var versionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.+-]+)?$`)
+
+func isValidIdentifier(s string) bool {
+	return identifierPattern.MatchString(s)
+}
+
+// ID returns the extension identifier, e.g. golang.go
+func (ext extension) ID() string {
+	return ext.Publisher + "." + ext.Name
+}
+
+func (ext extension) String() string {
+	s := ext.ID()
+	if ext.Source != "" {
+		s = ext.Source + ":" + s
+	}
+	if ext.Version != "" {
+		s += "@" + ext.Version
+	}
+	return s
+}
+
+// downloadAll downloads the assets resolved by the extension's source. Some assets may be
+// downloaded even if others fail. The entries of the previous lock file for downloads that no
+// longer match it are returned as kept.
+func downloadAll(src source, ext extension, targets []string, previous LockFile) (downloaded, kept []LockedExtension, err error) {
+	assets, errs := src.resolve(ext, targets)
+	for _, a := range assets {
+		locked, err := download(src.name(), ext, a, previous)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			var mismatch *lockMismatchError
+			if errors.As(err, &mismatch) {
+				kept = append(kept, mismatch.Expected)
+			}
+			continue
+		}
+		downloaded = append(downloaded, locked)
+	}
+	return downloaded, kept, errs
+}
+
+// lockMismatchError is returned when a download doesn't match the sha256 in the lock file.
+type lockMismatchError struct {
+	Expected LockedExtension
+	SHA256   string
+}
+
+func (e *lockMismatchError) Error() string {
+	return fmt.Sprintf("%s: expected sha256 %s from the lock file, but downloaded %s", e.Expected, e.Expected.SHA256, e.SHA256)
+}
+
+// download saves the VSIX asset of an extension, and its signature archive, if the source
+// publishes one. If the previous lock file contains the downloaded version, the VSIX must match
+// it, otherwise it's discarded without replacing the approved file.
+func download(sourceName string, ext extension, a asset, previous LockFile) (locked LockedExtension, err error) {
+	// Download to a temporary file, since the name includes the version, which isn't known
+	// until the VSIX has been downloaded if the latest version was requested.
+	downloadFileName, sha, err := downloadFile(a.URL)
+	if err != nil {
+		return locked, err
+	}
+	defer os.Remove(downloadFileName)
+
+	manifest, err := readManifest(downloadFileName)
+	if err != nil {
+		return locked, err
+	}
+	if !strings.EqualFold(manifest.Publisher+"."+manifest.Name, ext.ID()) {
+		return locked, fmt.Errorf("%s: expected extension %q, but downloaded %q", ext.ID(), ext.ID(), manifest.Publisher+"."+manifest.Name)
+	}
+	expectedVersion := ext.Version
+	if a.Version != "" {
+		expectedVersion = a.Version
+	}
+	if expectedVersion != "" && manifest.Version != expectedVersion {
+		return locked, fmt.Errorf("%s: expected version %q, but downloaded %q", ext.ID(), expectedVersion, manifest.Version)
+	}
+	if expected, ok := previous.find(sourceName, ext.ID(), manifest.Version, a.TargetPlatform); ok && !strings.EqualFold(expected.SHA256, sha) {
+		return locked, &lockMismatchError{Expected: expected, SHA256: sha}
+	}
+	targetFileName := path.Join("package/vsix", vsixFileName(manifest.Publisher, manifest.Name, manifest.Version, a.TargetPlatform))
+	newLocked := LockedExtension{
+		ID:             ext.ID(),
+		Version:        manifest.Version,
+		Source:         sourceName,
+		TargetPlatform: a.TargetPlatform,
+		SHA256:         sha,
+		FileName:       targetFileName,
+	}
+	if newLocked.Files, err = hashVSIXFiles(downloadFileName); err != nil {
+		return locked, err
+	}
+
+	// Check the VSIX against the signature before keeping either, so that a VSIX that fails
+	// verification doesn't replace the exported file.
+	var signatureFileName string
+	if a.SignatureURL != nil {
+		var signatureSHA string
+		signatureFileName, signatureSHA, err = downloadFile(a.SignatureURL(manifest.Version))
+		var se *statusError
+		switch {
+		case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+			// Not every version is signed.
+		case err != nil:
+			return locked, fmt.Errorf("%s: failed to download signature: %w", ext.ID(), err)
+		default:
+			defer os.Remove(signatureFileName)
+			if err = verifySignature(downloadFileName, signatureFileName); err != nil {
+				return locked, fmt.Errorf("%s: signature verification failed: %w", ext.ID(), err)
+			}
+			newLocked.SignatureFileName = strings.TrimSuffix(targetFileName, ".vsix") + ".sigzip"
+			newLocked.SignatureSHA256 = signatureSHA
+		}
+	}
+
+	// Keep the VSIX before its signature, so that a failure can't leave the new signature next to
+	// the previous VSIX. A VSIX of a locked version is the same as the approved one.
+	if err = os.Rename(downloadFileName, targetFileName); err != nil {
+		return locked, err
+	}
+	if newLocked.SignatureFileName != "" {
+		if err = os.Rename(signatureFileName, newLocked.SignatureFileName); err != nil {
+			return locked, err
+		}
+	}
+	return newLocked, nil
+}
+
+// statusError is returned when a download doesn't succeed.
+type statusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("expected status OK for %q, but got %d", e.URL, e.StatusCode)
+}
 
+// downloadFile saves the response to a temporary file in package/vsix, and returns its name and
+// hex encoded sha256.
+func downloadFile(from string) (fileName, sha string, err error) {
 	resp, err := http.Get(from)
 	if err != nil {
-		return err
+		return "", "", err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected status OK for %q, but got %d", from, resp.StatusCode)
+		return "", "", &statusError{URL: from, StatusCode: resp.StatusCode}
 	}
 
-	// Create the target.
-	targetFileName := path.Join("package/vsix", name+".vsix")
-	w, err := os.Create(targetFileName)
+	w, err := os.CreateTemp("package/vsix", ".download-*")
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	defer w.Close()
+
+	// Copy data, hashing it as it's written.
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(w, hash), resp.Body); err != nil {
+		os.Remove(w.Name())
+		return "", "", err
+	}
+	if err = w.Close(); err != nil {
+		os.Remove(w.Name())
+		return "", "", err
+	}
+	return w.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// vsixFileName returns the name of an exported VSIX, e.g. golang.go-0.41.0.vsix or
+// ms-python.python-2024.2.1@linux-x64.vsix
+func vsixFileName(publisher, name, version, targetPlatform string) string {
+	fileName := publisher + "." + name + "-" + version
+	if targetPlatform != "" {
+		fileName += "@" + targetPlatform
+	}
+	return fileName + ".vsix"
+}
+
+// manifest is the subset of the extension's package.json used by impex.
+type manifest struct {
+	Publisher string `json:"publisher"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	// ExtensionPack lists the extensions installed with an extension pack.
+	ExtensionPack []string `json:"extensionPack"`
+	// ExtensionDependencies lists the extensions that must be installed for the extension to
+	// activate.
+	ExtensionDependencies []string `json:"extensionDependencies"`
+}
+
+// dependency is an extension required by another extension.
+type dependency struct {
+	ID string
+	// Reason is the package.json field that lists the dependency, i.e. extensionPack or
+	// extensionDependencies.
+	Reason string
+}
+
+func (m manifest) dependencies() (deps []dependency) {
+	for _, id := range m.ExtensionPack {
+		deps = append(deps, dependency{ID: strings.TrimSpace(id), Reason: "extensionPack"})
+	}
+	for _, id := range m.ExtensionDependencies {
+		deps = append(deps, dependency{ID: strings.TrimSpace(id), Reason: "extensionDependencies"})
+	}
+	return deps
+}
+
+// isBuiltIn returns true for extensions bundled with VS Code, e.g. vscode.git, which aren't
+// published to the Marketplace.
+func isBuiltIn(id string) bool {
+	return strings.HasPrefix(strings.ToLower(id), "vscode.")
+}
+
+// readManifest reads extension/package.json from a VSIX, which is a zip file.
+func readManifest(fileName string) (m manifest, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open VSIX: %w", fileName, err)
 	}
+	defer zr.Close()
+	f, err := zr.Open("extension/package.json")
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open extension/package.json: %w", fileName, err)
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: failed to parse extension/package.json: %w", fileName, err)
+	}
+	return m, nil
+}
+
+// LockFile records the exact version and sha256 of each downloaded extension, so that the
+// export can be reproduced.
+type LockFile struct {
+	Extensions []LockedExtension `json:"extensions"`
+}
+
+type LockedExtension struct {
+	// ID is the extension identifier, e.g. golang.go
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	// Source is the name of the source the extension was downloaded from, e.g. marketplace.
+	Source string `json:"source,omitempty"`
+	// TargetPlatform is empty for the default build, e.g. a universal extension.
+	TargetPlatform string `json:"targetPlatform,omitempty"`
+	// SHA256 is the hex encoded sha256 of the VSIX file.
+	SHA256   string `json:"sha256"`
+	FileName string `json:"fileName"`
+	// SignatureFileName is the signature archive of the VSIX, if the source signs extensions.
+	SignatureFileName string `json:"signatureFileName,omitempty"`
+	// SignatureSHA256 is the hex encoded sha256 of the signature archive.
+	SignatureSHA256 string `json:"signatureSha256,omitempty"`
+	// Files maps the path of each file in the VSIX to its hex encoded sha256, so that the
+	// contents of unsigned extensions can be verified too.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// readLockFile reads the lock file, if it exists.
+func readLockFile(fileName string) (lockFile LockFile, err error) {
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return lockFile, nil
+	}
+	if err != nil {
+		return lockFile, err
+	}
+	if err = json.Unmarshal(data, &lockFile); err != nil {
+		return lockFile, fmt.Errorf("failed to parse lock file %q: %w", fileName, err)
+	}
+	return lockFile, nil
+}
+
+// sourceName returns the source of the extension. Lock files written before sources were
+// configurable only contain extensions from the Marketplace.
+func (le LockedExtension) sourceName() string {
+	if le.Source == "" {
+		return sourceMarketplace
+	}
+	return le.Source
+}
+
+// String returns the extension identifier and version, e.g. golang.go@0.41.0 or
+// golang.go@0.41.0 (linux-x64).
+func (le LockedExtension) String() string {
+	if le.TargetPlatform == "" {
+		return le.ID + "@" + le.Version
+	}
+	return le.ID + "@" + le.Version + " (" + le.TargetPlatform + ")"
+}
+
+// find returns the locked version of an extension. Extension identifiers are case insensitive.
+// Sources may publish different builds of the same version, so the source must match too.
+func (lf LockFile) find(source, id, version, targetPlatform string) (locked LockedExtension, ok bool) {
+	for _, locked := range lf.Extensions {
+		if locked.sourceName() == source && strings.EqualFold(locked.ID, id) && locked.Version == version && locked.TargetPlatform == targetPlatform {
+			return locked, true
+		}
+	}
+	return locked, false
+}
+
+// merge returns the extensions of the lock file, and the extensions of the previous lock file that
+// it doesn't contain.
+func (lf LockFile) merge(previous LockFile) (merged LockFile) {
+	merged.Extensions = append(merged.Extensions, lf.Extensions...)
+	for _, le := range previous.Extensions {
+		if _, ok := lf.find(le.sourceName(), le.ID, le.Version, le.TargetPlatform); !ok {
+			merged.Extensions = append(merged.Extensions, le)
+		}
+	}
+	return merged
+}
 
-	// Copy data.
-	_, err = io.Copy(w, resp.Body)
-	return err
+func writeLockFile(fileName string, lockFile LockFile) error {
+	sort.Slice(lockFile.Extensions, func(i, j int) bool {
+		a, b := lockFile.Extensions[i], lockFile.Extensions[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Version != b.Version {
+			return compareVersions(a.Version, b.Version) < 0
+		}
+		return a.TargetPlatform < b.TargetPlatform
+	})
+	data, err := json.MarshalIndent(lockFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(fileName), 0770); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(data, '\n'), 0660)
 }
 
 func createOutputDirectory() error {
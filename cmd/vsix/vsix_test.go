@@ -0,0 +1,161 @@
+package vsix
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"log/slog"
+)
+
+func TestParseExtension(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    extension
+		expectedErr bool
+	}{
+		{input: "golang.go", expected: extension{Publisher: "golang", Name: "go"}},
+		{input: "  golang.go  ", expected: extension{Publisher: "golang", Name: "go"}},
+		{input: "golang.go@0.41.0", expected: extension{Publisher: "golang", Name: "go", Version: "0.41.0"}},
+		{input: "ms-python.python@2024.2.1", expected: extension{Publisher: "ms-python", Name: "python", Version: "2024.2.1"}},
+		{input: "ms-vscode.cpptools@1.20.0-insiders", expected: extension{Publisher: "ms-vscode", Name: "cpptools", Version: "1.20.0-insiders"}},
+		{input: "rust-lang.rust_analyzer", expected: extension{Publisher: "rust-lang", Name: "rust_analyzer"}},
+		{input: "open-vsx:golang.go", expected: extension{Source: "open-vsx", Publisher: "golang", Name: "go"}},
+		{input: "marketplace:golang.go@0.41.0", expected: extension{Source: "marketplace", Publisher: "golang", Name: "go", Version: "0.41.0"}},
+		{input: "", expectedErr: true},
+		{input: "golang", expectedErr: true},
+		{input: "golang.", expectedErr: true},
+		{input: ".go", expectedErr: true},
+		{input: "golang.go.extra", expectedErr: true},
+		{input: "-golang.go", expectedErr: true},
+		{input: "golang/go", expectedErr: true},
+		{input: "golang.go@", expectedErr: true},
+		{input: "golang.go@latest", expectedErr: true},
+		{input: "golang.go@1.2", expectedErr: true},
+		{input: "golang.go@1.2.3@4.5.6", expectedErr: true},
+		{input: "github:golang.go", expectedErr: true},
+		{input: "https://marketplace.visualstudio.com/items?itemName=golang.go", expectedErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			actual, err := parseExtension(test.input)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLockFileMerge(t *testing.T) {
+	previous := LockFile{Extensions: []LockedExtension{
+		{ID: "golang.go", Version: "0.40.0", SHA256: "old"},
+		{ID: "golang.go", Version: "0.41.0", SHA256: "approved"},
+		{ID: "golang.go", Version: "0.41.0", Source: sourceOpenVSX, SHA256: "open-vsx"},
+		{ID: "ms-python.python", Version: "2024.2.1", TargetPlatform: "linux-x64", SHA256: "linux"},
+	}}
+	lockFile := LockFile{Extensions: []LockedExtension{
+		{ID: "GoLang.Go", Version: "0.41.0", Source: sourceMarketplace, SHA256: "approved"},
+		{ID: "ms-python.python", Version: "2024.2.1", Source: sourceMarketplace, TargetPlatform: "win32-x64", SHA256: "win32"},
+	}}
+	expected := []string{"approved", "win32", "old", "open-vsx", "linux"}
+
+	merged := lockFile.merge(previous)
+	var actual []string
+	for _, le := range merged.Extensions {
+		actual = append(actual, le.SHA256)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRunSources(t *testing.T) {
+	// Extensions are exported relative to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	vsix, err := os.ReadFile(writeVSIX(t, t.TempDir(), "pub", "ext", "1.0.0", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/pub/ext":
+			fmt.Fprintf(w, `{"namespace":"pub","name":"ext","version":"1.0.0","files":{"download":%q}}`, server.URL+"/pub.ext-1.0.0.vsix")
+		case "/pub.ext-1.0.0.vsix":
+			w.Write(vsix)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The previous export of another extension is kept in the lock file.
+	lockFileName := filepath.Join("package", "vsix", "vsix-lock.json")
+	previous := LockFile{Extensions: []LockedExtension{
+		{ID: "pub.other", Version: "2.0.0", Source: sourceOpenVSX, SHA256: "0123", FileName: "package/vsix/pub.other-2.0.0.vsix"},
+	}}
+	if err = writeLockFile(lockFileName, previous); err != nil {
+		t.Fatal(err)
+	}
+	listFileName := filepath.Join(dir, "vsix.txt")
+	list := "open-vsx:pub.ext\n" +
+		// The source defaults to the first entry's.
+		"pub.ext\n" +
+		"marketplace:PUB.ext@1.0.0\n"
+	if err = os.WriteFile(listFileName, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Run(Arguments{
+		FileName:   listFileName,
+		Source:     sourceMarketplace,
+		OpenVSXURL: server.URL,
+		Log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if expected := "vsix.txt:3: marketplace:PUB.ext@1.0.0 is already listed from open-vsx"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error to contain %q, got %v", expected, err)
+	}
+	if strings.Contains(err.Error(), "vsix.txt:2") {
+		t.Errorf("expected an entry without a source not to be reported, got %v", err)
+	}
+
+	lockFile, err := readLockFile(lockFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, le := range lockFile.Extensions {
+		actual = append(actual, le.sourceName()+":"+le.String())
+	}
+	expected := []string{"open-vsx:pub.ext@1.0.0", "open-vsx:pub.other@2.0.0"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected lock file %v, got %v", expected, actual)
+	}
+}
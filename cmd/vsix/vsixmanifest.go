@@ -0,0 +1,55 @@
+package vsix
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+)
+
+// packageManifest is the subset of extension.vsixmanifest, the VSIX package manifest, used by
+// impex.
+type packageManifest struct {
+	Metadata struct {
+		Identity struct {
+			ID        string `xml:"Id,attr"`
+			Version   string `xml:"Version,attr"`
+			Publisher string `xml:"Publisher,attr"`
+			// TargetPlatform is empty for universal extensions.
+			TargetPlatform string `xml:"TargetPlatform,attr"`
+		} `xml:"Identity"`
+		DisplayName string `xml:"DisplayName"`
+		Description string `xml:"Description"`
+		// Tags and Categories are comma separated.
+		Tags         string `xml:"Tags"`
+		Categories   string `xml:"Categories"`
+		GalleryFlags string `xml:"GalleryFlags"`
+		// Properties include the supported VS Code versions, and the extension's dependencies.
+		Properties []struct {
+			ID    string `xml:"Id,attr"`
+			Value string `xml:"Value,attr"`
+		} `xml:"Properties>Property"`
+	} `xml:"Metadata"`
+	// Assets are the files in the VSIX that are served by the Marketplace, e.g. the README.
+	Assets []struct {
+		Type string `xml:"Type,attr"`
+		Path string `xml:"Path,attr"`
+	} `xml:"Assets>Asset"`
+}
+
+// readPackageManifest reads extension.vsixmanifest from a VSIX.
+func readPackageManifest(fileName string) (m packageManifest, err error) {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open VSIX: %w", fileName, err)
+	}
+	defer zr.Close()
+	f, err := zr.Open("extension.vsixmanifest")
+	if err != nil {
+		return m, fmt.Errorf("%s: failed to open extension.vsixmanifest: %w", fileName, err)
+	}
+	defer f.Close()
+	if err = xml.NewDecoder(f).Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: failed to parse extension.vsixmanifest: %w", fileName, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,194 @@
+package vsix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/example-pipeline/impex/internal/list"
+)
+
+// workspaceExtensionFields are the fields of .vscode/extensions.json and devcontainer.json that
+// list extensions: the recommendations of extensions.json, the customizations.vscode.extensions
+// of devcontainer.json, and the extensions of devcontainer.json files written before
+// customizations were introduced.
+var workspaceExtensionFields = map[string]bool{
+	"recommendations":                  true,
+	"customizations.vscode.extensions": true,
+	"extensions":                       true,
+}
+
+// readWorkspaceExtensions returns the extensions listed by a .vscode/extensions.json or
+// devcontainer.json file, with their line numbers. Both are JSON with comments and trailing
+// commas.
+func readWorkspaceExtensions(fileName string) (entries []list.Entry, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	// The comments are replaced with spaces, so offsets in the stripped JSON match the file.
+	data = stripJSONC(data)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	err = walkJSON(dec, "", false, func(field string, value any) error {
+		if !workspaceExtensionFields[field] {
+			return nil
+		}
+		line := bytes.Count(data[:dec.InputOffset()], []byte("\n")) + 1
+		id, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("line %d: expected a string in %s, got %v", line, field, value)
+		}
+		id = strings.TrimSpace(id)
+		// Dev container features can remove an extension added by another feature with a
+		// leading -, which doesn't need to be downloaded.
+		if id == "" || strings.HasPrefix(id, "-") {
+			return nil
+		}
+		entries = append(entries, list.Entry{FileName: fileName, Line: line, Value: id})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to parse: %w", fileName, err)
+	}
+	return entries, nil
+}
+
+// walkJSON reads a JSON value, calling visit with each string, number, boolean or null in an
+// array, and the dot separated names of the fields containing the array, e.g.
+// customizations.vscode.extensions.
+func walkJSON(dec *json.Decoder, field string, inArray bool, visit func(field string, value any) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name := key.(string)
+			if field != "" {
+				name = field + "." + name
+			}
+			if err = walkJSON(dec, name, false, visit); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	case json.Delim('['):
+		for dec.More() {
+			if err = walkJSON(dec, field, true, visit); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+	if !inArray {
+		return nil
+	}
+	return visit(field, t)
+}
+
+// findWorkspaceFiles returns the .vscode/extensions.json and devcontainer.json files in the
+// directory tree. node_modules and .git directories are skipped.
+func findWorkspaceFiles(dir string) (fileNames []string, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && (d.Name() == "node_modules" || d.Name() == ".git") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isWorkspaceFile(p) {
+			fileNames = append(fileNames, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %q: %w", dir, err)
+	}
+	return fileNames, nil
+}
+
+// isWorkspaceFile matches .vscode/extensions.json, and the devcontainer.json locations supported
+// by the Dev Containers extension: .devcontainer.json, .devcontainer/devcontainer.json and
+// .devcontainer/<name>/devcontainer.json
+func isWorkspaceFile(p string) bool {
+	base := filepath.Base(p)
+	parent := filepath.Base(filepath.Dir(p))
+	switch base {
+	case "extensions.json":
+		return parent == ".vscode"
+	case ".devcontainer.json":
+		return true
+	case "devcontainer.json":
+		return parent == ".devcontainer" || filepath.Base(filepath.Dir(filepath.Dir(p))) == ".devcontainer"
+	}
+	return false
+}
+
+// stripJSONC converts JSON with comments to JSON, by removing // and /* */ comments, and
+// trailing commas before a closing bracket or brace. Comments are replaced with spaces, so that
+// offsets in errors still match the file.
+func stripJSONC(data []byte) []byte {
+	out := bytes.Clone(data)
+	var inString bool
+	// comma is the offset of a comma that may be trailing, or -1.
+	comma := -1
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			comma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(out)
+			} else {
+				end += i + 4
+			}
+			for ; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		case c == ',':
+			comma = i
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			comma = -1
+		}
+	}
+	return out
+}
@@ -0,0 +1,166 @@
+package vsix
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/example-pipeline/impex/internal/list"
+)
+
+func TestReadWorkspaceExtensions(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expected    []list.Entry
+		expectedErr bool
+	}{
+		{
+			name: "extensions.json recommendations",
+			data: `{
+  // Recommended for this workspace.
+  "recommendations": [
+    "golang.go",
+    /* "ms-python.python", */
+    "dbaeumer.vscode-eslint",
+  ],
+  "unwantedRecommendations": ["ms-vscode.cpptools"]
+}`,
+			expected: []list.Entry{
+				{Line: 4, Value: "golang.go"},
+				{Line: 6, Value: "dbaeumer.vscode-eslint"},
+			},
+		},
+		{
+			name: "devcontainer.json customizations and legacy extensions",
+			data: `{
+  "name": "Go",
+  "forwardPorts": [8080, 8081],
+  "mounts": [{"source": "cache", "target": "/cache", "type": "volume"}],
+  "customizations": {
+    "vscode": {
+      "extensions": ["golang.go", "-ms-vscode.cpptools"],
+      "settings": {"go.toolsManagement.autoUpdate": true}
+    }
+  },
+  "extensions": [
+    "redhat.vscode-yaml"
+  ]
+}`,
+			expected: []list.Entry{
+				{Line: 7, Value: "golang.go"},
+				{Line: 12, Value: "redhat.vscode-yaml"},
+			},
+		},
+		{
+			name:        "non-string extension",
+			data:        `{"recommendations": ["golang.go", 1]}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid JSON",
+			data:        `{"recommendations": ["golang.go"`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "extensions.json")
+			if err := os.WriteFile(fileName, []byte(test.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := readWorkspaceExtensions(fileName)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i := range test.expected {
+				test.expected[i].FileName = fileName
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "line comment",
+			input:    "{\"a\": 1 // comment\n}",
+			expected: "{\"a\": 1           \n}",
+		},
+		{
+			name:     "block comment",
+			input:    "{/* a\nb */\"a\": 1}",
+			expected: "{    \n    \"a\": 1}",
+		},
+		{
+			name:     "line comment inside a string",
+			input:    `{"url": "https://example.com"}`,
+			expected: `{"url": "https://example.com"}`,
+		},
+		{
+			name:     "block comment inside a string",
+			input:    `{"glob": "src/*/**/*.go"}`,
+			expected: `{"glob": "src/*/**/*.go"}`,
+		},
+		{
+			name:     "escaped quotes",
+			input:    `{"a": "say \"hi\" // not a comment", "b": "\\"} // comment`,
+			expected: `{"a": "say \"hi\" // not a comment", "b": "\\"}           `,
+		},
+		{
+			name:     "trailing commas",
+			input:    `{"a": [1, 2,], "b": {"c": 3,},}`,
+			expected: `{"a": [1, 2 ], "b": {"c": 3 } }`,
+		},
+		{
+			name:     "trailing comma followed by a comment",
+			input:    "[\"a\", // first\n\"b\", // last\n]",
+			expected: "[\"a\",         \n\"b\"         \n]",
+		},
+		{
+			name:     "trailing comma followed by a block comment",
+			input:    `["a", /* "b", */]`,
+			expected: `["a"            ]`,
+		},
+		{
+			name:     "comma in a string isn't trailing",
+			input:    `["a,"]`,
+			expected: `["a,"]`,
+		},
+		{
+			name:     "unterminated block comment",
+			input:    "{\"a\": 1} /* comment\nnot closed",
+			expected: "{\"a\": 1}           \n          ",
+		},
+		{
+			name:     "unterminated string",
+			input:    `{"a": "b // c`,
+			expected: `{"a": "b // c`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := string(stripJSONC([]byte(test.input)))
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+			if len(actual) != len(test.input) {
+				t.Errorf("expected offsets to be kept, got length %d for input of length %d", len(actual), len(test.input))
+			}
+		})
+	}
+}
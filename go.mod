@@ -8,6 +8,7 @@ require (
 	github.com/docker/docker v27.5.1+incompatible
 	github.com/go-git/go-git/v5 v5.13.2
 	github.com/google/go-github/v55 v55.0.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
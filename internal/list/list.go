@@ -0,0 +1,63 @@
+// Package list reads the input files of the exporters, which list one item per line, e.g. a
+// container image or an extension.
+package list
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Entry is an item in a list file.
+type Entry struct {
+	FileName string
+	// Line is the 1-based line number, or 0 if it isn't known.
+	Line  int
+	Value string
+}
+
+// Errorf returns an error that names the file and line of the entry, e.g.
+// vsix.txt:3: invalid extension.
+func (e Entry) Errorf(format string, a ...any) error {
+	return e.Wrap(fmt.Errorf(format, a...))
+}
+
+// Wrap adds the file and line of the entry to the error.
+func (e Entry) Wrap(err error) error {
+	if e.Line == 0 {
+		return fmt.Errorf("%s: %w", e.FileName, err)
+	}
+	return fmt.Errorf("%s:%d: %w", e.FileName, e.Line, err)
+}
+
+// Read reads the entries of a list file.
+func Read(fileName string) (entries []Entry, err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(fileName, string(data)), nil
+}
+
+// Parse returns the entries of a list, one per line. Whitespace around each entry is removed,
+// and empty lines and comments, i.e. lines starting with #, are skipped.
+func Parse(fileName, data string) (entries []Entry) {
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, Entry{FileName: fileName, Line: i + 1, Value: line})
+	}
+	return entries
+}
+
+// Split splits a comma separated list, e.g. a flag value, ignoring whitespace and empty values.
+func Split(s string) (values []string) {
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
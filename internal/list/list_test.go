@@ -0,0 +1,145 @@
+package list
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []Entry
+	}{
+		{
+			name: "empty",
+			data: "",
+		},
+		{
+			name: "one per line",
+			data: "a\nb\n",
+			expected: []Entry{
+				{FileName: "list.txt", Line: 1, Value: "a"},
+				{FileName: "list.txt", Line: 2, Value: "b"},
+			},
+		},
+		{
+			name: "comments and blank lines are skipped",
+			data: "# comment\n\na\n   \n  # indented comment\nb",
+			expected: []Entry{
+				{FileName: "list.txt", Line: 3, Value: "a"},
+				{FileName: "list.txt", Line: 6, Value: "b"},
+			},
+		},
+		{
+			name: "whitespace is trimmed",
+			data: "  a\t\n\tb  \n",
+			expected: []Entry{
+				{FileName: "list.txt", Line: 1, Value: "a"},
+				{FileName: "list.txt", Line: 2, Value: "b"},
+			},
+		},
+		{
+			name: "CRLF line endings",
+			data: "a\r\n\r\n# comment\r\nb\r\n",
+			expected: []Entry{
+				{FileName: "list.txt", Line: 1, Value: "a"},
+				{FileName: "list.txt", Line: 4, Value: "b"},
+			},
+		},
+		{
+			name: "# after a value isn't a comment",
+			data: "a#b",
+			expected: []Entry{
+				{FileName: "list.txt", Line: 1, Value: "a#b"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := Parse("list.txt", test.data)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(fileName, []byte("# images\nalpine:3.19\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := Read(fileName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Entry{{FileName: fileName, Line: 2, Value: "alpine:3.19"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	if _, err = Read(filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestEntryErrors(t *testing.T) {
+	errInvalid := errors.New("invalid extension")
+	tests := []struct {
+		name     string
+		entry    Entry
+		err      error
+		expected string
+	}{
+		{
+			name:     "file and line",
+			entry:    Entry{FileName: "vsix.txt", Line: 3, Value: "x"},
+			err:      errInvalid,
+			expected: "vsix.txt:3: invalid extension",
+		},
+		{
+			name:     "unknown line",
+			entry:    Entry{FileName: "extensions.json", Value: "x"},
+			err:      errInvalid,
+			expected: "extensions.json: invalid extension",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.entry.Wrap(test.err)
+			if err.Error() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, err.Error())
+			}
+			if !errors.Is(err, errInvalid) {
+				t.Errorf("expected the error to wrap %v", errInvalid)
+			}
+			if err = test.entry.Errorf("invalid %s", "extension"); err.Error() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "", expected: nil},
+		{input: "linux-x64", expected: []string{"linux-x64"}},
+		{input: "linux-x64,darwin-arm64", expected: []string{"linux-x64", "darwin-arm64"}},
+		{input: " linux-x64 , ,darwin-arm64, ", expected: []string{"linux-x64", "darwin-arm64"}},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			actual := Split(test.input)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
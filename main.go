@@ -5,11 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/example-pipeline/impex/cmd/container"
 	"github.com/example-pipeline/impex/cmd/git"
 	"github.com/example-pipeline/impex/cmd/npm"
 	"github.com/example-pipeline/impex/cmd/vsix"
+	"github.com/example-pipeline/impex/internal/list"
 )
 
 var help = `Usage: impex [cmd]
@@ -22,7 +25,20 @@ var help = `Usage: impex [cmd]
 Examples:
 
   impex npm export -lock-file=/package-lock.json
+  impex npm export -lock-file=/yarn.lock
+  impex npm export -lock-file=/pnpm-lock.yaml -registry=https://registry.npmjs.org
+  impex npm export -package=typescript@5 -package=react@^18.2.0
+  impex npm export -lock-file='services/**/package-lock.json' -lock-file=/yarn.lock
+  impex npm export -lock-file=/package-lock.json -os=linux -cpu=x64,arm64 -libc=glibc
+  impex npm import -registry=http://localhost:8081/repository/npm/ -username=admin -password=admin123
+  impex npm serve -addr=:8080
+  impex npm rewrite-lock -lock-file=/package-lock.json -registry=https://nexus.internal/repository/npm/
   impex vsix export -file=./vsix.txt
+  impex vsix export -file=./vsix.txt -source=open-vsx -target=linux-x64,linux-arm64
+  impex vsix export -file=./vsix.txt -scan-dir=../app -extensions-file=/.devcontainer/devcontainer.json
+  impex vsix import -registry=https://open-vsx.internal -token=ovsxp_fdsfdsfd
+  impex vsix serve -addr=:8080
+  impex vsix verify -lock-file=package/vsix/vsix-lock.json
   impex container export -file=./containers.txt
   impex git export -file=./git.txt -accessToken=ghp_fdsfdsfd
 `
@@ -68,12 +84,28 @@ func npmCmd(args []string) error {
 	case "export":
 		return npmExportCmd(args)
 	case "import":
-		return fmt.Errorf("not yet implemented")
+		return npmImportCmd(args)
+	case "serve":
+		return npmServeCmd(args)
+	case "rewrite-lock":
+		return npmRewriteLockCmd(args)
 	default:
-		return fmt.Errorf("impex npm subcommand missing, expected export or import")
+		return fmt.Errorf("impex npm subcommand missing, expected export, import, serve or rewrite-lock")
 	}
 }
 
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func ErrInvalidArgs(cmd *flag.FlagSet) error {
 	b := new(bytes.Buffer)
 	cmd.SetOutput(b)
@@ -83,14 +115,103 @@ func ErrInvalidArgs(cmd *flag.FlagSet) error {
 
 func npmExportCmd(args []string) error {
 	cmd := flag.NewFlagSet("export", flag.ExitOnError)
-	fileName := cmd.String("lock-file", "", "Path to the lock file.")
+	var fileNames stringsFlag
+	cmd.Var(&fileNames, "lock-file", "Path to a lock file, package-lock.json, yarn.lock or pnpm-lock.yaml, or a glob, e.g. **/package-lock.json. Can be repeated.")
+	registry := cmd.String("registry", "", "URL of the npm registry, used for lock files that don't contain tarball URLs. Defaults to the .npmrc registry, or https://registry.npmjs.org")
+	npmrc := cmd.String("npmrc", "", "Path to an .npmrc file with registry credentials, in addition to the project and user .npmrc files.")
+	var packages stringsFlag
+	cmd.Var(&packages, "package", "Package to resolve from the registry without a lock file, e.g. typescript@5. Can be repeated.")
+	packageFile := cmd.String("package-file", "", "Path to a list of packages to resolve from the registry, one per line.")
+	outputLockFile := cmd.String("output-lock-file", "package/npm/package-lock.json", "Path to write the lock file of the resolved packages to.")
+	osFlag := cmd.String("os", "", "Comma separated operating systems to export optional packages for, e.g. linux,darwin. Defaults to all.")
+	cpuFlag := cmd.String("cpu", "", "Comma separated CPU architectures to export optional packages for, e.g. x64,arm64. Defaults to all.")
+	libcFlag := cmd.String("libc", "", "Comma separated C libraries to export optional Linux packages for, e.g. glibc,musl. Defaults to all.")
+	concurrency := cmd.Int("concurrency", 4, "Number of packages to download at the same time.")
+	timeout := cmd.Duration("timeout", 5*time.Minute, "Maximum duration of each registry request, e.g. 30s or 5m.")
+	retries := cmd.Int("retries", 3, "Number of times to retry requests that fail with a server error, rate limiting or a network error.")
+	retryDelay := cmd.Duration("retry-delay", time.Second, "Wait before the first retry, doubled for each retry, unless the registry sends Retry-After.")
 	helpFlag := cmd.Bool("help", false, "Print help and exit.")
 	err := cmd.Parse(args)
-	if err != nil || *helpFlag || fileName == nil || *fileName == "" {
+	if err != nil || *helpFlag || (len(fileNames) == 0 && len(packages) == 0 && *packageFile == "") {
 		return ErrInvalidArgs(cmd)
 	}
+	if *packageFile != "" {
+		specs, err := npm.ReadPackageSpecs(*packageFile)
+		if err != nil {
+			return err
+		}
+		packages = append(packages, specs...)
+	}
 	return npm.Run(npm.Arguments{
-		FileName: *fileName,
+		FileNames:          fileNames,
+		Registry:           *registry,
+		NPMRC:              *npmrc,
+		Packages:           packages,
+		OutputLockFileName: *outputLockFile,
+		Concurrency:        *concurrency,
+		Timeout:            *timeout,
+		Retries:            *retries,
+		RetryDelay:         *retryDelay,
+		Platform: npm.Platform{
+			OS:   list.Split(*osFlag),
+			CPU:  list.Split(*cpuFlag),
+			Libc: list.Split(*libcFlag),
+		},
+	})
+}
+
+func npmImportCmd(args []string) error {
+	cmd := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := cmd.String("dir", "package/npm", "Path to the directory of tarballs to publish.")
+	registry := cmd.String("registry", "", "URL of the npm registry to publish to, e.g. http://localhost:8081/repository/npm/")
+	token := cmd.String("token", "", "Registry access token, for bearer authentication.")
+	username := cmd.String("username", "", "Registry username, for basic authentication.")
+	password := cmd.String("password", "", "Registry password, for basic authentication.")
+	timeout := cmd.Duration("timeout", 5*time.Minute, "Maximum duration of each registry request, e.g. 30s or 5m.")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *dir == "" || *registry == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return npm.Import(npm.ImportArguments{
+		Directory:   *dir,
+		RegistryURL: *registry,
+		Token:       *token,
+		Username:    *username,
+		Password:    *password,
+		Timeout:     *timeout,
+	})
+}
+
+func npmServeCmd(args []string) error {
+	cmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := cmd.String("dir", "package/npm", "Path to the directory of tarballs to serve.")
+	addr := cmd.String("addr", ":8080", "Address to listen on.")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *dir == "" || *addr == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return npm.Serve(npm.ServeArguments{
+		Directory: *dir,
+		Address:   *addr,
+	})
+}
+
+func npmRewriteLockCmd(args []string) error {
+	cmd := flag.NewFlagSet("rewrite-lock", flag.ExitOnError)
+	fileName := cmd.String("lock-file", "", "Path to the package-lock.json to rewrite in place.")
+	dir := cmd.String("dir", "package/npm", "Path to the directory of exported tarballs.")
+	registry := cmd.String("registry", "", "URL of the npm registry the tarballs were imported into, e.g. https://nexus.internal/repository/npm/")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *fileName == "" || *dir == "" || *registry == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return npm.RewriteLock(npm.RewriteArguments{
+		FileName:    *fileName,
+		Directory:   *dir,
+		RegistryURL: *registry,
 	})
 }
 
@@ -100,22 +221,85 @@ func vsixCmd(args []string) error {
 	case "export":
 		return vsixExportCmd(args)
 	case "import":
-		return fmt.Errorf("not yet implemented")
+		return vsixImportCmd(args)
+	case "serve":
+		return vsixServeCmd(args)
+	case "verify":
+		return vsixVerifyCmd(args)
 	default:
-		return fmt.Errorf("impex vsix subcommand missing, expected export or import")
+		return fmt.Errorf("impex vsix subcommand missing, expected export, import, serve or verify")
 	}
 }
 
 func vsixExportCmd(args []string) error {
 	cmd := flag.NewFlagSet("vsix", flag.ExitOnError)
-	fileName := cmd.String("file", "", "Path to the list of packages to download.")
+	fileName := cmd.String("file", "", "Path to the list of extensions to download, e.g. golang.go or golang.go@0.41.0, one per line.")
+	var workspaceFiles stringsFlag
+	cmd.Var(&workspaceFiles, "extensions-file", "Path to a .vscode/extensions.json or devcontainer.json file to add the extensions of. Can be repeated.")
+	var scanDirs stringsFlag
+	cmd.Var(&scanDirs, "scan-dir", "Directory to search for .vscode/extensions.json and devcontainer.json files. Can be repeated.")
+	outputLockFile := cmd.String("output-lock-file", "package/vsix/vsix-lock.json", "Path to write the version and sha256 of each downloaded extension to.")
+	sourceFlag := cmd.String("source", "marketplace", "Source to download extensions from, marketplace or open-vsx. Lines of the list can override it with a prefix, e.g. open-vsx:golang.go")
+	openVSXURL := cmd.String("open-vsx-url", "https://open-vsx.org", "Base URL of the Open VSX registry, e.g. a self-hosted instance.")
+	targetFlag := cmd.String("target", "", "Comma separated target platforms to download platform-specific builds for, e.g. linux-x64,linux-arm64,alpine-x64.")
 	helpFlag := cmd.Bool("help", false, "Print help and exit.")
 	err := cmd.Parse(args)
-	if err != nil || *helpFlag || fileName == nil || *fileName == "" {
+	if err != nil || *helpFlag || (*fileName == "" && len(workspaceFiles) == 0 && len(scanDirs) == 0) {
 		return ErrInvalidArgs(cmd)
 	}
 	return vsix.Run(vsix.Arguments{
-		FileName: *fileName,
+		FileName:           *fileName,
+		WorkspaceFiles:     workspaceFiles,
+		ScanDirectories:    scanDirs,
+		OutputLockFileName: *outputLockFile,
+		Source:             *sourceFlag,
+		OpenVSXURL:         *openVSXURL,
+		Targets:            list.Split(*targetFlag),
+	})
+}
+
+func vsixImportCmd(args []string) error {
+	cmd := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := cmd.String("dir", "package/vsix", "Path to the directory of extensions to publish.")
+	registry := cmd.String("registry", "", "URL of the Open VSX compatible registry to publish to, e.g. https://open-vsx.internal")
+	token := cmd.String("token", "", "Personal access token of a user allowed to publish to the registry.")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *dir == "" || *registry == "" || *token == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return vsix.Import(vsix.ImportArguments{
+		Directory:   *dir,
+		RegistryURL: *registry,
+		Token:       *token,
+	})
+}
+
+func vsixServeCmd(args []string) error {
+	cmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	dir := cmd.String("dir", "package/vsix", "Path to the directory of extensions to serve.")
+	addr := cmd.String("addr", ":8080", "Address to listen on.")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *dir == "" || *addr == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return vsix.Serve(vsix.ServeArguments{
+		Directory: *dir,
+		Address:   *addr,
+	})
+}
+
+func vsixVerifyCmd(args []string) error {
+	cmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	lockFile := cmd.String("lock-file", "package/vsix/vsix-lock.json", "Path to the lock file written by the export. Extensions are read from the same directory.")
+	helpFlag := cmd.Bool("help", false, "Print help and exit.")
+	err := cmd.Parse(args)
+	if err != nil || *helpFlag || *lockFile == "" {
+		return ErrInvalidArgs(cmd)
+	}
+	return vsix.Verify(vsix.VerifyArguments{
+		LockFileName: *lockFile,
 	})
 }
 